@@ -7,6 +7,7 @@ require (
 	github.com/gobwas/glob v0.2.3
 	github.com/goccy/go-yaml v1.17.1
 	github.com/pkg/xattr v0.4.10
+	github.com/pocketbase/dbx v1.11.0
 	github.com/pocketbase/pocketbase v0.27.1
 	github.com/spf13/cobra v1.9.1
 	github.com/syncthing/notify v0.0.0-20250207082249-f0fa8f99c2bc
@@ -26,7 +27,6 @@ require (
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
-	github.com/pocketbase/dbx v1.11.0 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	github.com/spf13/cast v1.7.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
@@ -0,0 +1,399 @@
+package bulk
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/textdiff"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+type BulkHandler struct {
+	app  *pocketbase.PocketBase
+	conf *config.NotebaseConfig
+
+	// only one job is applied at a time
+	runMu sync.Mutex
+}
+
+type Request struct {
+	Filter    string    `json:"filter"`
+	Operation Operation `json:"operation"`
+	DryRun    bool      `json:"dry_run"`
+}
+
+type Change struct {
+	Id    string `json:"id"`
+	Path  string `json:"path"`
+	Diff  string `json:"diff,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type Preview struct {
+	Matched int      `json:"matched"`
+	Changed int      `json:"changed"`
+	Changes []Change `json:"changes"`
+}
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) *BulkHandler {
+	return &BulkHandler{
+		app:  app,
+		conf: conf,
+	}
+}
+
+func (h *BulkHandler) Routes(se *core.ServeEvent) {
+	bulkGroup := se.Router.Group("/bulk")
+	bulkGroup.Bind(apis.RequireSuperuserAuth())
+	bulkGroup.POST("", func(e *core.RequestEvent) error {
+		req := Request{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request body", err)
+		}
+		if err := req.Operation.Validate(); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		if req.DryRun {
+			preview, err := h.Preview(req.Filter, req.Operation)
+			if err != nil {
+				return apis.NewBadRequestError("unable to preview", err)
+			}
+			return e.JSON(http.StatusOK, preview)
+		}
+		job, err := h.CreateJob(req.Filter, req.Operation)
+		if err != nil {
+			return apis.NewBadRequestError("unable to create job", err)
+		}
+		go h.Run(job.Id)
+		return e.JSON(http.StatusAccepted, job)
+	})
+	bulkGroup.GET("/{id}", func(e *core.RequestEvent) error {
+		job, err := h.app.FindRecordById("bulk_jobs", e.Request.PathValue("id"))
+		if err != nil {
+			return apis.NewNotFoundError("job not found", nil)
+		}
+		return e.JSON(http.StatusOK, job)
+	})
+	bulkGroup.POST("/{id}/resume", func(e *core.RequestEvent) error {
+		job, err := h.app.FindRecordById("bulk_jobs", e.Request.PathValue("id"))
+		if err != nil {
+			return apis.NewNotFoundError("job not found", nil)
+		}
+		if job.GetString("status") == StatusDone {
+			return apis.NewBadRequestError("job is already done", nil)
+		}
+		go h.Run(job.Id)
+		return e.JSON(http.StatusAccepted, job)
+	})
+}
+
+func (h *BulkHandler) findFiles(filter string) ([]*core.Record, error) {
+	filter = strings.TrimSpace(filter)
+	if filter == "" {
+		filter = "deleted = ''"
+	} else {
+		filter = "(" + filter + ") && deleted = ''"
+	}
+	return h.app.FindRecordsByFilter("files", filter, "path", 0, 0)
+}
+
+// Preview applies the operation in memory and returns a diff of the
+// frontmatter on disk and the frontmatter that would be written.
+func (h *BulkHandler) Preview(filter string, op Operation) (Preview, error) {
+	preview := Preview{Changes: []Change{}}
+	records, err := h.findFiles(filter)
+	if err != nil {
+		return preview, err
+	}
+	preview.Matched = len(records)
+	for _, record := range records {
+		change := Change{Id: record.Id, Path: record.GetString("path")}
+		after, changed, err := apply(record, op)
+		if err != nil {
+			change.Error = err.Error()
+			preview.Changes = append(preview.Changes, change)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		change.Diff = textdiff.Unified(
+			wrapFrontmatter(record.GetString("raw_frontmatter")),
+			wrapFrontmatter(utils.JsonToYaml(after)),
+			"a/"+change.Path,
+			"b/"+change.Path,
+			3,
+		)
+		preview.Changed++
+		preview.Changes = append(preview.Changes, change)
+	}
+	return preview, nil
+}
+
+func wrapFrontmatter(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	return "---\n" + raw + "---\n"
+}
+
+// apply returns the new frontmatter JSON of the record without modifying it.
+func apply(record *core.Record, op Operation) (string, bool, error) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return "", false, err
+	}
+	changed, err := op.Apply(fm)
+	if err != nil || !changed {
+		return "", false, err
+	}
+	after, err := fm.JSON()
+	if err != nil {
+		return "", false, err
+	}
+	return after, true, nil
+}
+
+// CreateJob snapshots the matching files, so that the job processes the
+// same set of files even if the operation changes what the filter matches.
+func (h *BulkHandler) CreateJob(filter string, op Operation) (*core.Record, error) {
+	records, err := h.findFiles(filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+	jobsCol, err := h.app.FindCollectionByNameOrId("bulk_jobs")
+	if err != nil {
+		return nil, err
+	}
+	job := core.NewRecord(jobsCol)
+	job.Set("filter", filter)
+	job.Set("operation", op)
+	job.Set("files", ids)
+	job.Set("processed", 0)
+	job.Set("status", StatusPending)
+	if err := h.app.Save(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run applies the job in batches. Each batch is saved in a transaction
+// together with the job progress, so an interrupted job can be resumed
+// from the last finished batch. Files are written to disk by the regular
+// OnRecordUpdate flow.
+func (h *BulkHandler) Run(jobId string) error {
+	h.runMu.Lock()
+	defer h.runMu.Unlock()
+
+	// the job is loaded after acquiring the lock to continue from the latest progress
+	job, err := h.app.FindRecordById("bulk_jobs", jobId)
+	if err != nil {
+		return err
+	}
+
+	op := Operation{}
+	if err := job.UnmarshalJSONField("operation", &op); err != nil {
+		return h.fail(job, err)
+	}
+	ids := []string{}
+	if err := job.UnmarshalJSONField("files", &ids); err != nil {
+		return h.fail(job, err)
+	}
+
+	job.Set("status", StatusRunning)
+	job.Set("error", "")
+	if err := h.app.Save(job); err != nil {
+		return err
+	}
+
+	for processed := job.GetInt("processed"); processed < len(ids); processed = job.GetInt("processed") {
+		end := min(processed+h.conf.SyncBatchSize, len(ids))
+		err := h.app.RunInTransaction(func(txApp core.App) error {
+			for _, id := range ids[processed:end] {
+				record, err := txApp.FindRecordById("files", id)
+				if err != nil || record.GetString("deleted") != "" {
+					// the file is gone since the job was created
+					continue
+				}
+				after, changed, err := apply(record, op)
+				if err != nil {
+					return fmt.Errorf("%s: %w", record.GetString("path"), err)
+				}
+				if !changed {
+					continue
+				}
+				record.Set("frontmatter", after)
+				if err := txApp.Save(record); err != nil {
+					return fmt.Errorf("%s: %w", record.GetString("path"), err)
+				}
+			}
+			job.Set("processed", end)
+			return txApp.Save(job)
+		})
+		if err != nil {
+			job.Set("processed", processed)
+			return h.fail(job, err)
+		}
+		h.app.Logger().Info("bulk job progress", "job", job.Id, "processed", end, "total", len(ids))
+	}
+
+	job.Set("status", StatusDone)
+	return h.app.Save(job)
+}
+
+func (h *BulkHandler) fail(job *core.Record, err error) error {
+	h.app.Logger().Error("bulk job failed", "job", job.Id, "error", err)
+	job.Set("status", StatusFailed)
+	job.Set("error", err.Error())
+	if saveErr := h.app.Save(job); saveErr != nil {
+		return errors.Join(err, saveErr)
+	}
+	return err
+}
+
+// ResumeInterrupted continues the jobs, which were running when the server stopped.
+func (h *BulkHandler) ResumeInterrupted() {
+	jobs, err := h.app.FindRecordsByFilter("bulk_jobs", "status = 'running'", "created", 0, 0)
+	if err != nil {
+		return
+	}
+	for _, job := range jobs {
+		h.app.Logger().Info("resuming bulk job", "job", job.Id)
+		go h.Run(job.Id)
+	}
+}
+
+func (h *BulkHandler) BulkCmd() *cobra.Command {
+	var (
+		filter string
+		apply  bool
+		resume string
+		rename string
+		set    string
+		unset  string
+		add    string
+		remove string
+		retype string
+	)
+	cmd := &cobra.Command{
+		Use:   "bulk",
+		Short: "Apply a frontmatter operation to all notes matching a filter (dry run by default)",
+		Example: `  notebase bulk --filter "path ~ 'activities/%'" --add tags=activity
+  notebase bulk --rename next_episode=next_air_date --apply
+  notebase bulk --resume <job id>`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if resume != "" {
+				job, err := h.app.FindRecordById("bulk_jobs", resume)
+				if err != nil {
+					return fmt.Errorf("job not found: %w", err)
+				}
+				return h.runAndReport(cmd, job)
+			}
+
+			op, err := operationFromFlags(rename, set, unset, add, remove, retype)
+			if err != nil {
+				return err
+			}
+			if err := op.Validate(); err != nil {
+				return err
+			}
+
+			preview, err := h.Preview(filter, op)
+			if err != nil {
+				return err
+			}
+			for _, change := range preview.Changes {
+				if change.Error != "" {
+					cmd.PrintErrf("%s: %s\n", change.Path, change.Error)
+					continue
+				}
+				cmd.Print(change.Diff)
+			}
+			cmd.Printf("%d matched, %d to change\n", preview.Matched, preview.Changed)
+			if !apply || preview.Changed == 0 {
+				return nil
+			}
+
+			job, err := h.CreateJob(filter, op)
+			if err != nil {
+				return err
+			}
+			return h.runAndReport(cmd, job)
+		},
+	}
+	cmd.Flags().StringVar(&filter, "filter", "", "PocketBase filter over the files collection")
+	cmd.Flags().BoolVar(&apply, "apply", false, "write the changes, otherwise only the diff is printed")
+	cmd.Flags().StringVar(&resume, "resume", "", "resume a failed or interrupted job by id")
+	cmd.Flags().StringVar(&rename, "rename", "", "rename a key, old=new")
+	cmd.Flags().StringVar(&set, "set", "", "set a key, key=value (value is parsed as YAML)")
+	cmd.Flags().StringVar(&unset, "unset", "", "remove a key")
+	cmd.Flags().StringVar(&add, "add", "", "add a value to a list, key=value")
+	cmd.Flags().StringVar(&remove, "remove", "", "remove a value from a list, key=value")
+	cmd.Flags().StringVar(&retype, "retype", "", "convert a value to string, number, bool, date or list, key=type")
+	return cmd
+}
+
+func (h *BulkHandler) runAndReport(cmd *cobra.Command, job *core.Record) error {
+	if err := h.Run(job.Id); err != nil {
+		return fmt.Errorf("job %s failed, fix the error and run with --resume %s: %w", job.Id, job.Id, err)
+	}
+	cmd.Printf("job %s done\n", job.Id)
+	return nil
+}
+
+func operationFromFlags(rename, set, unset, add, remove, retype string) (Operation, error) {
+	ops := []Operation{}
+	if rename != "" {
+		key, to, _ := strings.Cut(rename, "=")
+		ops = append(ops, Operation{Op: OpRename, Key: key, To: to})
+	}
+	if set != "" {
+		key, value, _ := strings.Cut(set, "=")
+		ops = append(ops, Operation{Op: OpSet, Key: key, Value: ParseValue(value)})
+	}
+	if unset != "" {
+		ops = append(ops, Operation{Op: OpUnset, Key: unset})
+	}
+	if add != "" {
+		key, value, _ := strings.Cut(add, "=")
+		ops = append(ops, Operation{Op: OpAdd, Key: key, Value: ParseValue(value)})
+	}
+	if remove != "" {
+		key, value, _ := strings.Cut(remove, "=")
+		ops = append(ops, Operation{Op: OpRemove, Key: key, Value: ParseValue(value)})
+	}
+	if retype != "" {
+		key, to, _ := strings.Cut(retype, "=")
+		ops = append(ops, Operation{Op: OpRetype, Key: key, To: to})
+	}
+	if len(ops) != 1 {
+		return Operation{}, errors.New("exactly one of --rename, --set, --unset, --add, --remove or --retype is required")
+	}
+	return ops[0], nil
+}
+
+// ParseValue parses a command line value as YAML, so that `4` is a number
+// and `[a, b]` is a list, while everything else stays a string.
+func ParseValue(raw string) any {
+	return frontmatter.Plain(frontmatter.ParseValue(raw))
+}
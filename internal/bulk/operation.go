@@ -0,0 +1,235 @@
+package bulk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/goccy/go-yaml"
+)
+
+const (
+	OpRename = "rename"
+	OpSet    = "set"
+	OpUnset  = "unset"
+	OpAdd    = "add"
+	OpRemove = "remove"
+	OpRetype = "retype"
+)
+
+// Operation is a single frontmatter change applied to every matching file.
+// To is the new key name for rename and the target type for retype.
+type Operation struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	To    string `json:"to,omitempty"`
+	Value any    `json:"value,omitempty"`
+}
+
+func (o Operation) Validate() error {
+	if o.Key == "" {
+		return errors.New("key is required")
+	}
+	switch o.Op {
+	case OpRename:
+		if o.To == "" {
+			return errors.New("rename requires a new key name in `to`")
+		}
+	case OpRetype:
+		switch o.To {
+		case "string", "number", "bool", "date", "list":
+		default:
+			return fmt.Errorf("unknown type %q, expected one of string, number, bool, date, list", o.To)
+		}
+	case OpAdd, OpRemove:
+		if o.Value == nil {
+			return fmt.Errorf("%s requires a value", o.Op)
+		}
+	case OpSet, OpUnset:
+	default:
+		return fmt.Errorf("unknown operation %q", o.Op)
+	}
+	return nil
+}
+
+// Apply changes the frontmatter in place and reports whether anything changed.
+func (o Operation) Apply(fm *frontmatter.Frontmatter) (bool, error) {
+	current, exists := fm.Get(o.Key)
+	switch o.Op {
+	case OpRename:
+		if !exists {
+			return false, nil
+		}
+		return fm.Rename(o.Key, o.To), nil
+	case OpSet:
+		if exists && equal(current, o.Value) {
+			return false, nil
+		}
+		fm.Set(o.Key, integers(o.Value))
+		return true, nil
+	case OpUnset:
+		return fm.Delete(o.Key), nil
+	case OpAdd:
+		list := toList(current)
+		for _, item := range list {
+			if equal(item, o.Value) {
+				return false, nil
+			}
+		}
+		fm.Set(o.Key, append(list, integers(o.Value)))
+		return true, nil
+	case OpRemove:
+		if !exists {
+			return false, nil
+		}
+		items, isList := current.([]any)
+		if !isList {
+			if equal(current, o.Value) {
+				return fm.Delete(o.Key), nil
+			}
+			return false, nil
+		}
+		kept := make([]any, 0, len(items))
+		for _, item := range items {
+			if !equal(item, o.Value) {
+				kept = append(kept, item)
+			}
+		}
+		if len(kept) == len(items) {
+			return false, nil
+		}
+		fm.Set(o.Key, kept)
+		return true, nil
+	case OpRetype:
+		if !exists {
+			return false, nil
+		}
+		converted, err := convert(frontmatter.Plain(current), o.To)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", o.Key, err)
+		}
+		if equal(current, converted) {
+			return false, nil
+		}
+		fm.Set(o.Key, converted)
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown operation %q", o.Op)
+}
+
+// equal compares the JSON representation, so that "4" and 4 are different values.
+func equal(a, b any) bool {
+	ja, _ := json.Marshal(frontmatter.Plain(a))
+	jb, _ := json.Marshal(frontmatter.Plain(b))
+	return string(ja) == string(jb)
+}
+
+func toList(value any) []any {
+	switch v := value.(type) {
+	case nil:
+		return []any{}
+	case []any:
+		return v
+	case string:
+		if v == "" {
+			return []any{}
+		}
+	}
+	return []any{value}
+}
+
+// integers applies number to a value decoded from JSON or from a command
+// line argument, where every number is a float64, and turns maps into
+// frontmatter mappings.
+func integers(value any) any {
+	switch v := value.(type) {
+	case float64:
+		return utils.Number(v)
+	case []any:
+		result := make([]any, len(v))
+		for i, item := range v {
+			result[i] = integers(item)
+		}
+		return result
+	case map[string]any:
+		// maps lose the order of their keys, so they are written sorted
+		result := make(yaml.MapSlice, 0, len(v))
+		for _, key := range slices.Sorted(maps.Keys(v)) {
+			result = append(result, yaml.MapItem{Key: key, Value: integers(v[key])})
+		}
+		return result
+	}
+	return value
+}
+
+func convert(value any, to string) (any, error) {
+	if value == nil {
+		if to == "list" {
+			return []any{}, nil
+		}
+		return nil, nil
+	}
+	switch to {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "number":
+		switch v := value.(type) {
+		case float64:
+			return utils.Number(v), nil
+		case string:
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", v)
+			}
+			return utils.Number(n), nil
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		}
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case float64:
+			return v != 0, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "y", "1", "x":
+				return true, nil
+			case "false", "no", "n", "0", "":
+				return false, nil
+			}
+			return nil, fmt.Errorf("%q is not a boolean", v)
+		}
+	case "date":
+		if v, ok := value.(string); ok {
+			t, ok := utils.ParseDate(v, time.UTC)
+			if !ok {
+				return nil, fmt.Errorf("%q is not a date", v)
+			}
+			if t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 {
+				return t.Format("2006-01-02"), nil
+			}
+			return t.Format("2006-01-02T15:04:05"), nil
+		}
+	case "list":
+		return toList(value), nil
+	}
+	return nil, fmt.Errorf("unable to convert %v to %s", value, to)
+}
@@ -0,0 +1,222 @@
+package caldav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// CalDAV clients only speak HTTP Basic auth, the credentials are either the
+// email and the password of a superuser or of an allowed user, or the email
+// and an app password of theirs. App passwords can be revoked one by one, so
+// that a lost phone does not require changing the account password.
+//
+// Anyone can sign up to users, so a user is only let in when it is verified
+// and its email is listed in caldav.users of the config.
+
+const (
+	appPasswordsCollection = "app_passwords"
+	usersCollection        = "users"
+)
+
+// authCollections are tried in order.
+var authCollections = []string{core.CollectionNameSuperusers, usersCollection}
+
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// AppPassword is an app password without its secret, which is shown once.
+type AppPassword struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Password string `json:"password,omitempty"`
+	Created  string `json:"created"`
+	LastUsed string `json:"last_used"`
+}
+
+type AppPasswordInput struct {
+	Name string `json:"name"`
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// allowed tells whether the auth record can use the calendar.
+func (h *CaldavHandler) allowed(record *core.Record) bool {
+	if record == nil {
+		return false
+	}
+	if record.IsSuperuser() {
+		return true
+	}
+	if record.Collection().Name != usersCollection || !record.Verified() {
+		return false
+	}
+	for _, email := range h.conf.Caldav.Users {
+		if strings.EqualFold(strings.TrimSpace(email), record.Email()) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticate checks the credentials of the Basic auth.
+func (h *CaldavHandler) authenticate(identity, password string) (*core.Record, error) {
+	for _, collection := range authCollections {
+		record, err := h.app.FindAuthRecordByEmail(collection, identity)
+		if err != nil || !h.allowed(record) {
+			continue
+		}
+		if record.ValidatePassword(password) {
+			return record, nil
+		}
+		appPassword, err := h.app.FindFirstRecordByFilter(
+			appPasswordsCollection,
+			"hash = {:hash} && collection = {:collection} && user = {:user}",
+			dbx.Params{"hash": hashPassword(password), "collection": record.Collection().Name, "user": record.Id},
+		)
+		if err != nil {
+			continue
+		}
+		appPassword.Set("last_used", types.NowDateTime())
+		if err := h.app.Save(appPassword); err != nil {
+			h.app.Logger().Warn("unable to update the app password", "error", err)
+		}
+		return record, nil
+	}
+	return nil, errInvalidCredentials
+}
+
+// requireAuth is the middleware of the CalDAV routes, it also makes sure that
+// the principal and the calendars in the path belong to the user.
+func (h *CaldavHandler) requireAuth(e *core.RequestEvent) error {
+	identity, password, ok := e.Request.BasicAuth()
+	if ok {
+		record, err := h.authenticate(identity, password)
+		if err == nil {
+			e.Auth = record
+			if user := e.Request.PathValue("user"); user != "" && user != record.Id {
+				return davError(e, http.StatusForbidden, "<D:need-privileges/>")
+			}
+			return e.Next()
+		}
+		h.app.Logger().Debug("caldav authentication failed", "identity", identity)
+	}
+	e.Response.Header().Set("WWW-Authenticate", `Basic realm="notebase", charset="UTF-8"`)
+	return e.NoContent(http.StatusUnauthorized)
+}
+
+// requireWriter guards the routes, which change the notes, so that they are
+// never reachable without an allowed user, even when they are moved out of
+// the authenticated group.
+func (h *CaldavHandler) requireWriter(e *core.RequestEvent) error {
+	if !h.allowed(e.Auth) {
+		e.Response.Header().Set("WWW-Authenticate", `Basic realm="notebase", charset="UTF-8"`)
+		return e.NoContent(http.StatusUnauthorized)
+	}
+	return e.Next()
+}
+
+func appPasswordOf(record *core.Record) AppPassword {
+	return AppPassword{
+		Id:       record.Id,
+		Name:     record.GetString("name"),
+		Created:  record.GetString("created"),
+		LastUsed: record.GetString("last_used"),
+	}
+}
+
+// AppPasswords lists the app passwords of a user.
+func (h *CaldavHandler) AppPasswords(auth *core.Record) ([]AppPassword, error) {
+	records, err := h.app.FindRecordsByFilter(
+		appPasswordsCollection,
+		"collection = {:collection} && user = {:user}",
+		"-created", 0, 0,
+		dbx.Params{"collection": auth.Collection().Name, "user": auth.Id},
+	)
+	if err != nil {
+		return nil, err
+	}
+	passwords := []AppPassword{}
+	for _, record := range records {
+		passwords = append(passwords, appPasswordOf(record))
+	}
+	return passwords, nil
+}
+
+// CreateAppPassword generates a new app password, only its hash is stored.
+func (h *CaldavHandler) CreateAppPassword(auth *core.Record, name string) (AppPassword, error) {
+	collection, err := h.app.FindCollectionByNameOrId(appPasswordsCollection)
+	if err != nil {
+		return AppPassword{}, err
+	}
+	password := security.RandomString(24)
+	record := core.NewRecord(collection)
+	record.Set("collection", auth.Collection().Name)
+	record.Set("user", auth.Id)
+	record.Set("name", name)
+	record.Set("hash", hashPassword(password))
+	if err := h.app.Save(record); err != nil {
+		return AppPassword{}, err
+	}
+	result := appPasswordOf(record)
+	result.Password = password
+	return result, nil
+}
+
+// RevokeAppPassword deletes an app password of a user.
+func (h *CaldavHandler) RevokeAppPassword(auth *core.Record, id string) error {
+	record, err := h.app.FindRecordById(appPasswordsCollection, id)
+	if err != nil || record.GetString("collection") != auth.Collection().Name || record.GetString("user") != auth.Id {
+		return errors.New("app password not found")
+	}
+	return h.app.Delete(record)
+}
+
+func (h *CaldavHandler) passwordRoutes(se *core.ServeEvent) {
+	passwordsGroup := se.Router.Group("/caldav/app-passwords")
+	passwordsGroup.Bind(apis.RequireAuth(authCollections...))
+	passwordsGroup.BindFunc(func(e *core.RequestEvent) error {
+		if !h.allowed(e.Auth) {
+			return apis.NewForbiddenError("the calendar is not available to this user", nil)
+		}
+		return e.Next()
+	})
+	passwordsGroup.GET("", func(e *core.RequestEvent) error {
+		passwords, err := h.AppPasswords(e.Auth)
+		if err != nil {
+			return apis.NewBadRequestError("unable to list the app passwords", err)
+		}
+		return e.JSON(http.StatusOK, passwords)
+	})
+	passwordsGroup.POST("", func(e *core.RequestEvent) error {
+		input := AppPasswordInput{}
+		if err := e.BindBody(&input); err != nil {
+			return apis.NewBadRequestError("invalid request body", err)
+		}
+		input.Name = strings.TrimSpace(input.Name)
+		if input.Name == "" {
+			return apis.NewBadRequestError("name is required", nil)
+		}
+		password, err := h.CreateAppPassword(e.Auth, input.Name)
+		if err != nil {
+			return apis.NewBadRequestError("unable to create the app password", err)
+		}
+		return e.JSON(http.StatusCreated, password)
+	})
+	passwordsGroup.DELETE("/{id}", func(e *core.RequestEvent) error {
+		if err := h.RevokeAppPassword(e.Auth, e.Request.PathValue("id")); err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		return e.NoContent(http.StatusNoContent)
+	})
+}
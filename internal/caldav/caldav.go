@@ -1,29 +1,139 @@
 package caldav
 
 import (
-	"log"
+	"encoding/xml"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/ical"
 	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 )
 
 type CaldavHandler struct {
-	app  *pocketbase.PocketBase
-	root string
+	app   *pocketbase.PocketBase
+	root  string
+	conf  *config.NotebaseConfig
+	notes Notes
+	// loc is the timezone of the dates without one
+	loc *time.Location
+	// mu serializes the writes of the clients
+	mu sync.Mutex
 }
 
-func NewHandler(app *pocketbase.PocketBase, root string, config *config.NotebaseConfig) *CaldavHandler {
+func NewHandler(app *pocketbase.PocketBase, root string, config *config.NotebaseConfig, notes Notes) *CaldavHandler {
 	return &CaldavHandler{
-		app:  app,
-		root: root,
+		app:   app,
+		root:  root,
+		conf:  config,
+		notes: notes,
+		loc:   config.Location(),
 	}
 }
 
-// initCaldavRoutes registers minimal CalDAV endpoints.
+func dav(local string) xml.Name    { return xml.Name{Space: nsDAV, Local: local} }
+func caldav(local string) xml.Name { return xml.Name{Space: nsCalDAV, Local: local} }
+func cs(local string) xml.Name     { return xml.Name{Space: nsCS, Local: local} }
+
+// baseURL is the URL of the web UI, which the events link to.
+func (h *CaldavHandler) baseURL(e *core.RequestEvent) string {
+	if h.conf.Caldav.URL != "" {
+		return h.conf.Caldav.URL
+	}
+	scheme := "http"
+	if e.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := e.Request.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + e.Request.Host
+}
+
+// The principal and the calendar home are per user, the calendar itself is
+// generated from the same notes for everyone.
+func principalHref(auth *core.Record) string {
+	return "/caldav/principals/" + auth.Id + "/"
+}
+
+func homeHref(auth *core.Record) string {
+	return "/caldav/calendars/" + auth.Id + "/"
+}
+
+func collectionHref(auth *core.Record) string {
+	return homeHref(auth) + "default/"
+}
+
+func displayName(auth *core.Record) string {
+	if email := auth.Email(); email != "" {
+		return email
+	}
+	return auth.Id
+}
+
+func (h *CaldavHandler) rootProps(auth *core.Record) props {
+	return props{
+		dav("current-user-principal"): hrefXML(principalHref(auth)),
+		dav("resourcetype"):           "<D:collection/>",
+	}
+}
+
+func (h *CaldavHandler) principalProps(auth *core.Record) props {
+	return props{
+		dav("current-user-principal"): hrefXML(principalHref(auth)),
+		dav("principal-URL"):          hrefXML(principalHref(auth)),
+		dav("resourcetype"):           "<D:principal/>",
+		dav("displayname"):            escape(displayName(auth)),
+		caldav("calendar-home-set"):   hrefXML(homeHref(auth)),
+	}
+}
+
+func (h *CaldavHandler) homeProps(auth *core.Record) props {
+	return props{
+		dav("current-user-principal"): hrefXML(principalHref(auth)),
+		dav("resourcetype"):           "<D:collection/>",
+		dav("displayname"):            "Calendars",
+	}
+}
+
+// collectionProps of the calendar, the sync token is also its CTag.
+func (h *CaldavHandler) collectionProps(auth *core.Record, syncToken string) props {
+	return props{
+		dav("sync-token"):                          escape(syncToken),
+		cs("getctag"):                              escape(syncToken),
+		dav("current-user-principal"):              hrefXML(principalHref(auth)),
+		dav("resourcetype"):                        "<D:collection/><C:calendar/>",
+		dav("displayname"):                         escape(h.conf.Caldav.Name),
+		caldav("calendar-description"):             escape(h.conf.Caldav.Name + " from notebase"),
+		caldav("supported-calendar-component-set"): `<C:comp name="VEVENT"/><C:comp name="VTODO"/>`,
+		dav("supported-report-set"): "<D:supported-report><D:report><C:calendar-query/></D:report></D:supported-report>" +
+			"<D:supported-report><D:report><C:calendar-multiget/></D:report></D:supported-report>" +
+			"<D:supported-report><D:report><D:sync-collection/></D:report></D:supported-report>",
+	}
+}
+
+func resourceProps(resource Resource, withData bool) props {
+	values := props{
+		dav("getetag"):        escape(resource.ETag()),
+		dav("getcontenttype"): "text/calendar; charset=utf-8; component=" + resource.Component.Name,
+		dav("resourcetype"):   "",
+	}
+	if withData {
+		values[caldav("calendar-data")] = escape(resource.Data())
+	}
+	return values
+}
+
+// Routes registers the CalDAV endpoints. Every user has a principal and a
+// single collection, which is generated from the notes. The events are
+// read-only, the tasks can be changed by the clients. The routes use HTTP
+// Basic auth instead of the PocketBase tokens.
 func (h *CaldavHandler) Routes(se *core.ServeEvent) {
 	// Handle all common discovery paths
 	discoveryPaths := []string{
@@ -32,104 +142,242 @@ func (h *CaldavHandler) Routes(se *core.ServeEvent) {
 		"/principals/",
 		"/calendar/dav/",
 	}
-
 	for _, path := range discoveryPaths {
 		se.Router.Route("PROPFIND", path, func(e *core.RequestEvent) error {
-			log.Printf("CalDAV Discovery Request: %s %s", e.Request.Method, e.Request.URL.Path)
-			log.Printf("Request Headers: %v", e.Request.Header)
-
-			xmlResponse := `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:"><D:response><D:href>/</D:href><D:propstat><D:prop><D:current-user-principal><D:href>/caldav/principals/me/</D:href></D:current-user-principal><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
-
-			e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
-			e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
-			log.Printf("Response Headers: %v", e.Response.Header())
-			return e.String(http.StatusMultiStatus, xmlResponse)
-		}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
+			return h.propfind(e, h.rootProps(e.Auth), nil)
+		}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId).BindFunc(h.requireAuth)
 	}
 
 	// Add .well-known/caldav redirect
 	se.Router.Route("GET", "/.well-known/caldav", func(e *core.RequestEvent) error {
-		log.Printf("CalDAV Request: %s %s", e.Request.Method, e.Request.URL.Path)
 		e.Response.Header().Set("Location", "/caldav/")
 		return e.NoContent(http.StatusPermanentRedirect)
 	}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
 
-	caldav := se.Router.Group("/caldav")
-
-	// Root PROPFIND - Discovery endpoint
-	caldav.Route("PROPFIND", "/", func(e *core.RequestEvent) error {
-		log.Printf("CalDAV Request: %s %s", e.Request.Method, e.Request.URL.Path)
-		log.Printf("Request Headers: %v", e.Request.Header)
-
-		xmlResponse := `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:"><D:response><D:href>/</D:href><D:propstat><D:prop><D:current-user-principal><D:href>/caldav/principals/me/</D:href></D:current-user-principal><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
-
-		e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
-		e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		log.Printf("Response Headers: %v", e.Response.Header())
-		return e.String(http.StatusMultiStatus, xmlResponse)
-	}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
-
-	// Principal PROPFIND
-	caldav.Route("PROPFIND", "/principals/me/", func(e *core.RequestEvent) error {
-		log.Printf("CalDAV Request: %s %s", e.Request.Method, e.Request.URL.Path)
-		log.Printf("Request Headers: %v", e.Request.Header)
-
-		xmlResponse := `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:response><D:href>/caldav/principals/me/</D:href><D:propstat><D:prop><C:calendar-home-set><D:href>/caldav/calendars/</D:href></C:calendar-home-set><D:resourcetype><D:principal/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
+	h.passwordRoutes(se)
 
-		e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
-		e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		log.Printf("Response Headers: %v", e.Response.Header())
-		return e.String(http.StatusMultiStatus, xmlResponse)
-	}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
+	caldavGroup := se.Router.Group("/caldav")
+	caldavGroup.Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
+	caldavGroup.BindFunc(h.requireAuth)
+	caldavGroup.Route("OPTIONS", "/", func(e *core.RequestEvent) error {
+		setDAVHeaders(e)
+		e.Response.Header().Set("Allow", "OPTIONS, GET, PUT, DELETE, PROPFIND, REPORT")
+		return e.NoContent(http.StatusOK)
+	})
+	caldavGroup.Route("PROPFIND", "/{$}", func(e *core.RequestEvent) error {
+		return h.propfind(e, h.rootProps(e.Auth), nil)
+	})
+	caldavGroup.Route("PROPFIND", "/principals/{user}/", func(e *core.RequestEvent) error {
+		return h.propfind(e, h.principalProps(e.Auth), nil)
+	})
+	caldavGroup.Route("PROPFIND", "/calendars/{user}/{$}", func(e *core.RequestEvent) error {
+		return h.propfind(e, h.homeProps(e.Auth), func(m *multistatus, requested []xml.Name) error {
+			syncToken, err := h.SyncToken()
+			if err != nil {
+				return err
+			}
+			m.add(collectionHref(e.Auth), h.collectionProps(e.Auth, syncToken), requested)
+			return nil
+		})
+	})
+	caldavGroup.Route("PROPFIND", "/calendars/{user}/default/{$}", func(e *core.RequestEvent) error {
+		syncToken, err := h.SyncToken()
+		if err != nil {
+			return apis.NewBadRequestError("unable to load the calendar", err)
+		}
+		return h.propfind(e, h.collectionProps(e.Auth, syncToken), func(m *multistatus, requested []xml.Name) error {
+			resources, err := h.Resources(h.baseURL(e))
+			if err != nil {
+				return err
+			}
+			for _, resource := range resources {
+				m.add(collectionHref(e.Auth)+resource.Name, resourceProps(resource, false), requested)
+			}
+			return nil
+		})
+	})
+	caldavGroup.Route("REPORT", "/calendars/{user}/default/{$}", func(e *core.RequestEvent) error {
+		return h.report(e)
+	})
+	caldavGroup.Route("GET", "/calendars/{user}/default/{name}", func(e *core.RequestEvent) error {
+		resource, ok := h.Resource(e.Request.PathValue("name"), h.baseURL(e))
+		if !ok {
+			return e.NoContent(http.StatusNotFound)
+		}
+		e.Response.Header().Set("ETag", resource.ETag())
+		e.Response.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		return e.String(http.StatusOK, resource.Data())
+	})
+	caldavGroup.Route("PUT", "/calendars/{user}/default/{name}", func(e *core.RequestEvent) error {
+		return h.put(e)
+	}).BindFunc(h.requireWriter)
+	caldavGroup.Route("DELETE", "/calendars/{user}/default/{name}", func(e *core.RequestEvent) error {
+		return h.delete(e)
+	}).BindFunc(h.requireWriter)
+}
 
-	// Calendar home PROPFIND
-	caldav.Route("PROPFIND", "/calendars/", func(e *core.RequestEvent) error {
-		log.Printf("CalDAV Request: %s %s", e.Request.Method, e.Request.URL.Path)
-		log.Printf("Request Headers: %v", e.Request.Header)
+func setDAVHeaders(e *core.RequestEvent) {
+	e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
+}
 
-		xmlResponse := `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:response><D:href>/caldav/calendars/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype><D:displayname>Calendars</D:displayname></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
+func writeMultistatus(e *core.RequestEvent, m *multistatus) error {
+	setDAVHeaders(e)
+	e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	return e.String(http.StatusMultiStatus, m.String())
+}
 
-		e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
-		e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		log.Printf("Response Headers: %v", e.Response.Header())
-		return e.String(http.StatusMultiStatus, xmlResponse)
-	}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
+// propfind responds with the properties of the resource and, with Depth: 1,
+// with the properties of its members.
+func (h *CaldavHandler) propfind(e *core.RequestEvent, values props, members func(*multistatus, []xml.Name) error) error {
+	h.app.Logger().Debug("caldav request", "method", e.Request.Method, "path", e.Request.URL.Path, "depth", e.Request.Header.Get("Depth"))
+	req := propfindRequest{}
+	if err := parseBody(e.Request.Body, &req); err != nil {
+		return apis.NewBadRequestError("invalid PROPFIND body", err)
+	}
+	requested := req.Prop.requested()
+	m := newMultistatus()
+	m.add(e.Request.URL.Path, values, requested)
+	if members != nil && e.Request.Header.Get("Depth") == "1" {
+		if err := members(m, requested); err != nil {
+			return err
+		}
+	}
+	return writeMultistatus(e, m)
+}
 
-	// Calendar collection PROPFIND
-	caldav.Route("PROPFIND", "/calendars/default/", func(e *core.RequestEvent) error {
-		log.Printf("CalDAV Request: %s %s", e.Request.Method, e.Request.URL.Path)
-		log.Printf("Request Headers: %v", e.Request.Header)
+// report handles calendar-query, calendar-multiget and sync-collection.
+func (h *CaldavHandler) report(e *core.RequestEvent) error {
+	h.app.Logger().Debug("caldav request", "method", e.Request.Method, "path", e.Request.URL.Path)
+	req := reportRequest{}
+	if err := parseBody(e.Request.Body, &req); err != nil {
+		return apis.NewBadRequestError("invalid REPORT body", err)
+	}
+	requested := req.Prop.requested()
+	if requested == nil {
+		requested = []xml.Name{dav("getetag"), caldav("calendar-data")}
+	}
+	withData := false
+	for _, name := range requested {
+		withData = withData || name == caldav("calendar-data")
+	}
+	baseURL := h.baseURL(e)
+	m := newMultistatus()
+	switch req.XMLName {
+	case caldav("calendar-multiget"):
+		for _, href := range req.Hrefs {
+			name := path.Base(hrefPath(href))
+			resource, ok := h.Resource(name, baseURL)
+			if !ok {
+				m.notFound(href)
+				continue
+			}
+			m.add(href, resourceProps(resource, withData), requested)
+		}
+	case caldav("calendar-query"):
+		resources, err := h.Resources(baseURL)
+		if err != nil {
+			return apis.NewBadRequestError("unable to load the calendar", err)
+		}
+		for _, resource := range resources {
+			if !matches(resource, req.Filter, h.loc) {
+				continue
+			}
+			m.add(collectionHref(e.Auth)+resource.Name, resourceProps(resource, withData), requested)
+		}
+	case dav("sync-collection"):
+		// the token is taken first, the changes made meanwhile are
+		// reported again by the next sync
+		syncToken, err := h.SyncToken()
+		if err != nil {
+			return apis.NewBadRequestError("unable to load the calendar", err)
+		}
+		since := time.Time{}
+		if req.SyncToken != "" {
+			if since, err = parseSyncToken(req.SyncToken); err != nil {
+				return davError(e, http.StatusForbidden, "<D:valid-sync-token/>")
+			}
+		}
+		changed, removed, err := h.Changes(since, baseURL)
+		if err != nil {
+			return apis.NewBadRequestError("unable to load the changes", err)
+		}
+		for _, resource := range changed {
+			m.add(collectionHref(e.Auth)+resource.Name, resourceProps(resource, withData), requested)
+		}
+		for _, name := range removed {
+			m.notFound(collectionHref(e.Auth) + name)
+		}
+		m.syncToken = syncToken
+	default:
+		return davError(e, http.StatusForbidden, "<D:supported-report/>")
+	}
+	return writeMultistatus(e, m)
+}
 
-		xmlResponse := `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:response><D:href>/caldav/calendars/default/</D:href><D:propstat><D:prop><D:resourcetype><D:collection/><C:calendar/></D:resourcetype><D:displayname>Default Calendar</D:displayname><C:calendar-description>Default calendar</C:calendar-description><C:calendar-timezone>UTC</C:calendar-timezone><C:supported-calendar-component-set><C:comp name="VEVENT"/></C:supported-calendar-component-set></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
+// matches checks the component filter of a calendar-query, the top level
+// filter is the VCALENDAR and its children name the components and limit
+// them to a time range.
+func matches(resource Resource, filter *compFilter, loc *time.Location) bool {
+	if filter == nil || len(filter.CompFilters) == 0 {
+		return true
+	}
+	for _, child := range filter.CompFilters {
+		if !strings.EqualFold(child.Name, resource.Component.Name) {
+			continue
+		}
+		if child.TimeRange == nil || inTimeRange(resource.Component, *child.TimeRange, loc) {
+			return true
+		}
+	}
+	return false
+}
 
-		e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
-		e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		log.Printf("Response Headers: %v", e.Response.Header())
-		return e.String(http.StatusMultiStatus, xmlResponse)
-	}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
+// inTimeRange is a simplified version of the rules of RFC 4791 9.9, events
+// overlap the range, tasks are due or have been completed in it and the
+// tasks without either of the dates are always in it.
+func inTimeRange(component ical.Component, tr timeRange, loc *time.Location) bool {
+	start, end := time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if t, err := time.Parse("20060102T150405Z", tr.Start); err == nil {
+		start = t
+	}
+	if t, err := time.Parse("20060102T150405Z", tr.End); err == nil {
+		end = t
+	}
+	propTime := func(name string) (time.Time, bool) {
+		prop, ok := component.Prop(name)
+		if !ok {
+			return time.Time{}, false
+		}
+		t, _, err := prop.Time(loc)
+		return t, err == nil
+	}
+	switch component.Name {
+	case "VEVENT":
+		dtstart, ok := propTime("DTSTART")
+		if !ok {
+			return false
+		}
+		dtend, ok := propTime("DTEND")
+		if !ok {
+			dtend = dtstart
+		}
+		return dtstart.Before(end) && (dtend.After(start) || (dtend.Equal(dtstart) && !dtstart.Before(start)))
+	case "VTODO":
+		if due, ok := propTime("DUE"); ok {
+			return !due.Before(start) && due.Before(end)
+		}
+		if completed, ok := propTime("COMPLETED"); ok {
+			return !completed.Before(start) && !completed.After(end)
+		}
+		return true
+	}
+	return true
+}
 
-	// Calendar REPORT
-	caldav.Route("REPORT", "/calendars/default/", func(e *core.RequestEvent) error {
-		log.Printf("CalDAV Request: %s %s", e.Request.Method, e.Request.URL.Path)
-		log.Printf("Request Headers: %v", e.Request.Header)
-
-		now := time.Now()
-		xmlResponse := `<?xml version="1.0" encoding="utf-8"?><D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav"><D:response><D:href>/caldav/calendars/default/event1.ics</D:href><D:propstat><D:prop><D:getetag>"1"</D:getetag><C:calendar-data>BEGIN:VCALENDAR
-VERSION:2.0
-PRODID:-//Example Corp.//CalDAV Client//EN
-BEGIN:VEVENT
-UID:event1@example.com
-DTSTAMP:` + now.Format("20060102T150405Z") + `
-DTSTART:` + now.Format("20060102T150405Z") + `
-DTEND:` + now.Add(time.Hour).Format("20060102T150405Z") + `
-SUMMARY:Test Event
-DESCRIPTION:This is a test event
-END:VEVENT
-END:VCALENDAR</C:calendar-data></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response></D:multistatus>`
-
-		e.Response.Header().Set("DAV", "1, 2, 3, calendar-access")
-		e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
-		log.Printf("Response Headers: %v", e.Response.Header())
-		return e.String(http.StatusMultiStatus, xmlResponse)
-	}).Unbind(apis.DefaultLoadAuthTokenMiddlewareId)
+// hrefPath strips the scheme and the host of absolute hrefs.
+func hrefPath(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		return u.Path
+	}
+	return href
 }
@@ -0,0 +1,169 @@
+package caldav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/ical"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Resource is a calendar object of the collection, which is generated from
+// a note. Its name is stable, it is derived from the record id and the
+// property of the event or the id of the task.
+type Resource struct {
+	Name      string
+	Record    *core.Record
+	Component ical.Component
+	// line is the content line of a checkbox task, -1 for the whole note
+	line int
+}
+
+// Data is the iCalendar object of the resource.
+func (r Resource) Data() string {
+	calendar := ical.NewCalendar("")
+	calendar.Components = []ical.Component{r.Component}
+	return calendar.String()
+}
+
+// ETag changes whenever the generated object changes.
+func (r Resource) ETag() string {
+	sum := sha256.Sum256([]byte(r.Data()))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+func resourceName(record *core.Record, property string) string {
+	return record.Id + "-" + strings.ReplaceAll(property, "_", "-") + ".ics"
+}
+
+// resources returns the events and the tasks of a note.
+func (h *CaldavHandler) resources(record *core.Record, baseURL string) []Resource {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return nil
+	}
+	resources := []Resource{}
+	// the dates of task notes belong to their VTODOs
+	if !h.isTaskNote(fm) {
+		resources = append(resources, h.events(record, fm, baseURL)...)
+	}
+	return append(resources, h.todos(record, fm, baseURL)...)
+}
+
+// events returns the events of a note, one per configured date property,
+// which the note has.
+func (h *CaldavHandler) events(record *core.Record, fm *frontmatter.Frontmatter, baseURL string) []Resource {
+	resources := []Resource{}
+	for _, conf := range h.conf.Caldav.Events {
+		if conf.Type != "" && fm.GetString("type") != conf.Type {
+			continue
+		}
+		event, ok := h.event(record, fm, conf, baseURL)
+		if !ok {
+			continue
+		}
+		resources = append(resources, Resource{
+			Name:      resourceName(record, conf.Start),
+			Record:    record,
+			Component: event,
+			line:      -1,
+		})
+	}
+	return resources
+}
+
+func (h *CaldavHandler) event(record *core.Record, fm *frontmatter.Frontmatter, conf config.CaldavEventConfig, baseURL string) (ical.Component, bool) {
+	value := fm.GetString(conf.Start)
+	start, ok := utils.ParseDate(value, h.loc)
+	if !ok {
+		return ical.Component{}, false
+	}
+	allDay := len(strings.TrimSpace(value)) == len(time.DateOnly)
+	end := start.Add(time.Hour)
+	if allDay {
+		end = start.AddDate(0, 0, 1)
+	}
+	if conf.End != "" {
+		if value, ok := utils.ParseDate(fm.GetString(conf.End), h.loc); ok && !value.Before(start) {
+			end = value
+			// all-day events end on the next day of the inclusive end date
+			if allDay {
+				end = value.AddDate(0, 0, 1)
+			}
+		}
+	}
+
+	path := record.GetString("path")
+	summary := fm.GetString("title")
+	if summary == "" {
+		summary = fm.GetString("summary")
+	}
+	if summary == "" {
+		summary = templates.Title(path)
+	}
+	noteURL := strings.TrimSuffix(baseURL, "/") + "/items/" + url.PathEscape(record.Id)
+	modified := record.GetDateTime("updated").Time()
+
+	event := ical.Component{Name: "VEVENT"}
+	event.Add("UID", fmt.Sprintf("%s-%s@notebase", record.Id, conf.Start))
+	event.Add("DTSTAMP", ical.DateTime(modified))
+	event.Add("LAST-MODIFIED", ical.DateTime(modified))
+	if allDay {
+		event.Add("DTSTART", ical.Date(start), ical.Param{Name: "VALUE", Value: "DATE"})
+		event.Add("DTEND", ical.Date(end), ical.Param{Name: "VALUE", Value: "DATE"})
+	} else {
+		event.Add("DTSTART", ical.DateTime(start))
+		event.Add("DTEND", ical.DateTime(end))
+	}
+	event.Add("SUMMARY", ical.Text(summary))
+	event.Add("DESCRIPTION", ical.Text(path+"\n"+noteURL))
+	event.Add("URL", noteURL, ical.Param{Name: "VALUE", Value: "URI"})
+	if noteType := fm.GetString("type"); noteType != "" {
+		event.Add("CATEGORIES", ical.Text(noteType))
+	}
+	return event, true
+}
+
+// Resources returns every resource of the calendar.
+func (h *CaldavHandler) Resources(baseURL string) ([]Resource, error) {
+	records, err := h.app.FindRecordsByFilter("files", "deleted = ''", "path", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resources := []Resource{}
+	for _, record := range records {
+		resources = append(resources, h.resources(record, baseURL)...)
+	}
+	return resources, nil
+}
+
+// Resource finds a resource by its name in the collection. The names of the
+// tasks created by the clients are kept in the notes.
+func (h *CaldavHandler) Resource(name, baseURL string) (Resource, bool) {
+	record, err := h.app.FindFirstRecordByFilter("files", "frontmatter.caldav_name = {:name} && deleted = ''", dbx.Params{"name": name})
+	if err != nil {
+		id, _, ok := strings.Cut(name, "-")
+		if !ok {
+			return Resource{}, false
+		}
+		record, err = h.app.FindRecordById("files", id)
+		if err != nil || record.GetString("deleted") != "" {
+			return Resource{}, false
+		}
+	}
+	for _, resource := range h.resources(record, baseURL) {
+		if resource.Name == name {
+			return resource, true
+		}
+	}
+	return Resource{}, false
+}
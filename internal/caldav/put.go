@@ -0,0 +1,210 @@
+package caldav
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/ical"
+	"github.com/biozz/wow/notebase/internal/revisions"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Notes creates and removes the task notes, which are added and deleted by
+// the clients.
+type Notes interface {
+	templates.NoteCreator
+	RemoveNote(record *core.Record) error
+}
+
+var errChanged = errors.New("the task has changed since it was read")
+
+// preconditionFailed compares If-Match and If-None-Match with the current
+// ETag, so that the changes made in the vault since the client has read the
+// resource are not overwritten.
+func preconditionFailed(e *core.RequestEvent, resource Resource, exists bool) bool {
+	if match := e.Request.Header.Get("If-Match"); match != "" {
+		if !exists || (match != "*" && !etagMatches(match, resource.ETag())) {
+			return true
+		}
+	}
+	if noneMatch := e.Request.Header.Get("If-None-Match"); noneMatch != "" && exists {
+		if noneMatch == "*" || etagMatches(noneMatch, resource.ETag()) {
+			return true
+		}
+	}
+	return false
+}
+
+func etagMatches(header, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(value), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func davError(e *core.RequestEvent, status int, condition string) error {
+	setDAVHeaders(e)
+	e.Response.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	return e.String(status, `<?xml version="1.0" encoding="utf-8"?><D:error xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">`+condition+`</D:error>`)
+}
+
+// put creates or updates a task, events are generated from the notes and
+// can not be changed by the clients.
+func (h *CaldavHandler) put(e *core.RequestEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	name := e.Request.PathValue("name")
+	h.app.Logger().Debug("caldav request", "method", e.Request.Method, "path", e.Request.URL.Path)
+	calendar, err := ical.Decode(e.Request.Body)
+	if err != nil {
+		return davError(e, http.StatusBadRequest, "<C:valid-calendar-data/>")
+	}
+	component, ok := calendar.Find("VTODO")
+	if !ok {
+		return davError(e, http.StatusForbidden, "<C:supported-calendar-component/>")
+	}
+	task, err := taskOf(component, h.loc)
+	if err != nil {
+		return davError(e, http.StatusBadRequest, "<C:valid-calendar-data/>")
+	}
+
+	baseURL := h.baseURL(e)
+	resource, exists := h.Resource(name, baseURL)
+	if preconditionFailed(e, resource, exists) {
+		return e.NoContent(http.StatusPreconditionFailed)
+	}
+	status := http.StatusNoContent
+	if exists {
+		if resource.Component.Name != "VTODO" {
+			return davError(e, http.StatusForbidden, "<C:supported-calendar-component/>")
+		}
+		revisions.SetUser(resource.Record, e.Auth)
+		err = h.updateTask(resource, task)
+	} else {
+		err = h.createTask(name, component.Get("UID"), task)
+		status = http.StatusCreated
+	}
+	if err != nil {
+		return apis.NewBadRequestError("unable to save the task", err)
+	}
+	// the stored task is rebuilt from the note, so it is not the one sent by
+	// the client and no ETag is returned (RFC 4791 5.3.4)
+	return e.NoContent(status)
+}
+
+func (h *CaldavHandler) delete(e *core.RequestEvent) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.app.Logger().Debug("caldav request", "method", e.Request.Method, "path", e.Request.URL.Path)
+	resource, exists := h.Resource(e.Request.PathValue("name"), h.baseURL(e))
+	if !exists {
+		return e.NoContent(http.StatusNotFound)
+	}
+	if preconditionFailed(e, resource, exists) {
+		return e.NoContent(http.StatusPreconditionFailed)
+	}
+	if resource.Component.Name != "VTODO" {
+		return davError(e, http.StatusForbidden, "<C:supported-calendar-component/>")
+	}
+	revisions.SetUser(resource.Record, e.Auth)
+	var err error
+	if resource.line < 0 {
+		// the content of the note is not a part of the task
+		if strings.TrimSpace(resource.Record.GetString("content")) != "" {
+			return davError(e, http.StatusForbidden, "<D:need-privileges/>")
+		}
+		err = h.notes.RemoveNote(resource.Record)
+	} else {
+		err = h.editLine(resource, func(m []string) []string { return nil })
+	}
+	if err != nil {
+		return apis.NewBadRequestError("unable to delete the task", err)
+	}
+	return e.NoContent(http.StatusNoContent)
+}
+
+// updateTask writes a task to the frontmatter of its note or to its checkbox
+// line, the regular OnRecordUpdate flow writes the note to disk.
+func (h *CaldavHandler) updateTask(resource Resource, task Task) error {
+	record := resource.Record
+	if resource.line >= 0 {
+		id := strings.TrimSuffix(strings.TrimPrefix(resource.Name, record.Id+"-"), ".ics")
+		return h.editLine(resource, func(m []string) []string {
+			return []string{checkboxLine(m, id, task, h.loc)}
+		})
+	}
+	fm, err := frontmatter.Parse(record.GetString("raw_frontmatter"))
+	if err != nil {
+		return err
+	}
+	applyTask(fm, task, h.loc)
+	return fm.Save(h.app, record)
+}
+
+// editLine replaces the checkbox line of a task with the lines returned by
+// change.
+func (h *CaldavHandler) editLine(resource Resource, change func(m []string) []string) error {
+	record := resource.Record
+	lines := strings.Split(record.GetString("content"), "\n")
+	if resource.line >= len(lines) {
+		return errChanged
+	}
+	m := taskRe.FindStringSubmatch(lines[resource.line])
+	if m == nil {
+		return errChanged
+	}
+	result := append([]string{}, lines[:resource.line]...)
+	result = append(result, change(m)...)
+	result = append(result, lines[resource.line+1:]...)
+	record.Set("content", strings.Join(result, "\n"))
+	record.Set("origin", "db")
+	return h.app.Save(record)
+}
+
+// unsafeNameRe matches the characters of a resource name, which are not
+// kept in the file name of a new task note.
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// createTask creates a task note for a task added by a client, the resource
+// name and the UID of the client are kept in the note.
+func (h *CaldavHandler) createTask(name, uid string, task Task) error {
+	noteType := "task"
+	if len(h.conf.Caldav.Tasks.Types) > 0 {
+		noteType = h.conf.Caldav.Tasks.Types[0]
+	}
+	fm, err := frontmatter.Parse("")
+	if err != nil {
+		return err
+	}
+	fm.Set("type", noteType)
+	applyTask(fm, task, h.loc)
+	if uid != "" {
+		fm.Set(uidKey, uid)
+	}
+	fm.Set(nameKey, name)
+	rawFrontmatter, err := fm.YAML()
+	if err != nil {
+		return err
+	}
+	// the resource name is unique, unlike the time of the upload, when a
+	// client uploads several tasks at once
+	fileName := unsafeNameRe.ReplaceAllString(strings.TrimSuffix(name, ".ics"), "")
+	if fileName == "" {
+		fileName = time.Now().Format("20060102150405")
+	}
+	relPath := filepath.Join(h.conf.Caldav.Tasks.Folder, fmt.Sprintf("%s_%s.md", noteType, fileName))
+	_, err = h.notes.CreateNote(relPath, rawFrontmatter, "")
+	return err
+}
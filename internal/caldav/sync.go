@@ -0,0 +1,187 @@
+package caldav
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Sync tokens (RFC 6578) are the time of the latest change of the notes or
+// of the resources, which were removed from them. Changes made at the time
+// of the token are reported again by the next sync, so that changes made in
+// the same millisecond are not lost.
+//
+// The resources of the notes are not stored, so the resources, which have
+// been reported, are kept in caldav_resources. It is reconciled with the
+// changed notes on every sync and tells which resources have been removed.
+
+const (
+	resourcesCollection = "caldav_resources"
+	syncTokenPrefix     = "https://notebase/ns/sync/"
+)
+
+var errInvalidSyncToken = errors.New("invalid sync token")
+
+func formatSyncToken(t time.Time) string {
+	return syncTokenPrefix + strconv.FormatInt(t.UnixMilli(), 10)
+}
+
+func parseSyncToken(token string) (time.Time, error) {
+	value, ok := strings.CutPrefix(strings.TrimSpace(token), syncTokenPrefix)
+	if !ok {
+		return time.Time{}, errInvalidSyncToken
+	}
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, errInvalidSyncToken
+	}
+	return time.UnixMilli(millis).UTC(), nil
+}
+
+// SyncToken is the current sync token of the collection, it is also used as
+// its CTag.
+func (h *CaldavHandler) SyncToken() (string, error) {
+	latest := time.Time{}
+	for _, query := range []string{
+		"SELECT COALESCE(MAX(updated), '') FROM files",
+		"SELECT COALESCE(MAX(removed), '') FROM " + resourcesCollection,
+	} {
+		var value string
+		if err := h.app.DB().NewQuery(query).Row(&value); err != nil {
+			return "", err
+		}
+		if value == "" {
+			continue
+		}
+		t, err := types.ParseDateTime(value)
+		if err != nil {
+			return "", err
+		}
+		if t.Time().After(latest) {
+			latest = t.Time()
+		}
+	}
+	return formatSyncToken(latest), nil
+}
+
+// Changes returns the resources of the notes changed since the time and the
+// names of the resources removed since then. The zero time returns all of
+// the resources.
+func (h *CaldavHandler) Changes(since time.Time, baseURL string) ([]Resource, []string, error) {
+	filter, params := "deleted = ''", dbx.Params{}
+	if !since.IsZero() {
+		dt, err := types.ParseDateTime(since)
+		if err != nil {
+			return nil, nil, err
+		}
+		filter, params = "updated >= {:since}", dbx.Params{"since": dt.String()}
+	}
+	records, err := h.app.FindRecordsByFilter("files", filter, "path", 0, 0, params)
+	if err != nil {
+		return nil, nil, err
+	}
+	changed := []Resource{}
+	current := map[string]bool{}
+	byFile := map[string][]Resource{}
+	for _, record := range records {
+		resources := []Resource{}
+		if record.GetString("deleted") == "" {
+			resources = h.resources(record, baseURL)
+		}
+		byFile[record.Id] = resources
+		for _, resource := range resources {
+			current[resource.Name] = true
+		}
+		changed = append(changed, resources...)
+	}
+	if err := h.reconcile(byFile); err != nil {
+		return nil, nil, err
+	}
+	if since.IsZero() {
+		return changed, nil, nil
+	}
+
+	removedRows, err := h.app.FindRecordsByFilter(
+		resourcesCollection,
+		"removed != '' && removed >= {:since}",
+		"name", 0, 0,
+		params,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	removed := []string{}
+	for _, row := range removedRows {
+		if name := row.GetString("name"); !current[name] {
+			removed = append(removed, name)
+		}
+	}
+	return changed, removed, nil
+}
+
+// reconcile updates the reported resources of the notes, the ones, which
+// are gone, are marked as removed. Concurrent syncs of the clients are
+// serialized, so that a resource is not added twice.
+func (h *CaldavHandler) reconcile(byFile map[string][]Resource) error {
+	if len(byFile) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	collection, err := h.app.FindCollectionByNameOrId(resourcesCollection)
+	if err != nil {
+		return err
+	}
+	ids := make([]any, 0, len(byFile))
+	for id := range byFile {
+		ids = append(ids, id)
+	}
+	rows, err := h.app.FindAllRecords(resourcesCollection, dbx.In("file", ids...))
+	if err != nil {
+		return err
+	}
+	known := map[string]*core.Record{}
+	for _, row := range rows {
+		known[row.GetString("file")+"/"+row.GetString("name")] = row
+	}
+
+	now := types.NowDateTime()
+	return h.app.RunInTransaction(func(txApp core.App) error {
+		for id, resources := range byFile {
+			names := map[string]bool{}
+			for _, resource := range resources {
+				names[resource.Name] = true
+				row, ok := known[id+"/"+resource.Name]
+				switch {
+				case !ok:
+					row = core.NewRecord(collection)
+					row.Set("name", resource.Name)
+					row.Set("file", id)
+				case row.GetString("removed") != "":
+					row.Set("removed", "")
+				default:
+					continue
+				}
+				if err := txApp.Save(row); err != nil {
+					return err
+				}
+			}
+			for _, row := range rows {
+				if row.GetString("file") != id || names[row.GetString("name")] || row.GetString("removed") != "" {
+					continue
+				}
+				row.Set("removed", now)
+				if err := txApp.Save(row); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
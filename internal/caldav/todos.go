@@ -0,0 +1,374 @@
+package caldav
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/ical"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Frontmatter keys of task notes, `due` and `completed` are the ones of
+// recurring notes. `uid` and `caldav_name` keep the identity of the tasks,
+// which were created by the clients.
+const (
+	summaryKey   = "summary"
+	dueKey       = "due"
+	completedKey = "completed"
+	priorityKey  = "priority"
+	uidKey       = "uid"
+	nameKey      = "caldav_name"
+)
+
+// Obsidian Tasks markers, see https://publish.obsidian.md/tasks/Reference/Task+Formats/Tasks+Emoji+Format
+var (
+	taskRe       = regexp.MustCompile(`^(\s*[-*+] \[)(.)(\] )(.*)$`)
+	taskDateRe   = regexp.MustCompile(` ?(📅|⏳|🛫|✅|➕|❌) ?(\d{4}-\d{2}-\d{2})`)
+	taskDoneRe   = regexp.MustCompile(` ?✅ ?\d{4}-\d{2}-\d{2}`)
+	taskIdRe     = regexp.MustCompile(` ?🆔 ?([A-Za-z0-9_-]+)`)
+	taskRepeatRe = regexp.MustCompile(` ?🔁 ?[^📅⏳🛫✅➕❌🔺⏫🔼🔽⏬🆔⛔]+`)
+	taskDependRe = regexp.MustCompile(` ?⛔ ?[A-Za-z0-9_,-]+`)
+	priorityRe   = regexp.MustCompile(` ?(🔺|⏫|🔼|🔽|⏬)`)
+)
+
+// priorities map the Tasks priorities to the iCalendar ones, 1 is the
+// highest and 9 is the lowest.
+var priorities = []struct {
+	Marker string
+	Name   string
+	Value  int
+}{
+	{"🔺", "highest", 1},
+	{"⏫", "high", 3},
+	{"🔼", "medium", 5},
+	{"🔽", "low", 7},
+	{"⏬", "lowest", 9},
+}
+
+// Task is the part of a VTODO, which is kept in the notes.
+type Task struct {
+	Summary   string
+	Due       time.Time
+	DueAllDay bool
+	Completed time.Time
+	Cancelled bool
+	Priority  int
+}
+
+// priorityIndex returns the index of the closest Tasks priority.
+func priorityIndex(value int) int {
+	switch {
+	case value <= 2:
+		return 0
+	case value <= 4:
+		return 1
+	case value == 5:
+		return 2
+	case value <= 8:
+		return 3
+	}
+	return 4
+}
+
+func parsePriority(value string) int {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 && n <= 9 {
+		return n
+	}
+	for _, p := range priorities {
+		if p.Name == value {
+			return p.Value
+		}
+	}
+	return 0
+}
+
+// taskOf reads a VTODO sent by a client.
+func taskOf(todo ical.Component, loc *time.Location) (Task, error) {
+	task := Task{Summary: strings.TrimSpace(ical.Unescape(todo.Get("SUMMARY")))}
+	if prop, ok := todo.Prop("DUE"); ok {
+		due, allDay, err := prop.Time(loc)
+		if err != nil {
+			return Task{}, fmt.Errorf("invalid DUE: %w", err)
+		}
+		task.Due, task.DueAllDay = due, allDay
+	}
+	status := strings.ToUpper(todo.Get("STATUS"))
+	if prop, ok := todo.Prop("COMPLETED"); ok {
+		completed, _, err := prop.Time(loc)
+		if err != nil {
+			return Task{}, fmt.Errorf("invalid COMPLETED: %w", err)
+		}
+		task.Completed = completed
+	} else if status == "COMPLETED" {
+		task.Completed = time.Now()
+	}
+	if status == "NEEDS-ACTION" || status == "IN-PROCESS" {
+		task.Completed = time.Time{}
+	}
+	task.Cancelled = status == "CANCELLED"
+	if value := todo.Get("PRIORITY"); value != "" {
+		priority, err := strconv.Atoi(value)
+		if err != nil || priority < 0 || priority > 9 {
+			return Task{}, fmt.Errorf("invalid PRIORITY %q", value)
+		}
+		task.Priority = priority
+	}
+	return task, nil
+}
+
+func (h *CaldavHandler) isTaskNote(fm *frontmatter.Frontmatter) bool {
+	noteType := fm.GetString("type")
+	return noteType != "" && slices.Contains(h.conf.Caldav.Tasks.Types, noteType)
+}
+
+func (h *CaldavHandler) hasCheckboxes(path string) bool {
+	for _, folder := range h.conf.Caldav.Tasks.Checkboxes {
+		if strings.HasPrefix(path, strings.TrimSuffix(folder, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// todos returns the VTODOs of a note, the note itself when it is a task
+// note and its checkbox tasks.
+func (h *CaldavHandler) todos(record *core.Record, fm *frontmatter.Frontmatter, baseURL string) []Resource {
+	resources := []Resource{}
+	if h.isTaskNote(fm) {
+		name := fm.GetString(nameKey)
+		if name == "" {
+			name = resourceName(record, "task")
+		}
+		uid := fm.GetString(uidKey)
+		if uid == "" {
+			uid = record.Id + "-task@notebase"
+		}
+		resources = append(resources, Resource{
+			Name:      name,
+			Record:    record,
+			Component: todo(record, noteTask(record, fm, h.loc), uid, baseURL),
+			line:      -1,
+		})
+	}
+	if !h.hasCheckboxes(record.GetString("path")) {
+		return resources
+	}
+	seen := map[string]int{}
+	for i, line := range strings.Split(record.GetString("content"), "\n") {
+		m := taskRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		id := checkboxId(m[4])
+		// identical tasks are told apart by their order
+		seen[id]++
+		if seen[id] > 1 {
+			id += "-" + strconv.Itoa(seen[id])
+		}
+		resources = append(resources, Resource{
+			Name:      record.Id + "-" + id + ".ics",
+			Record:    record,
+			Component: todo(record, checkboxTask(m, record.GetDateTime("updated").Time(), h.loc), record.Id+"-"+id+"@notebase", baseURL),
+			line:      i,
+		})
+	}
+	return resources
+}
+
+func noteTask(record *core.Record, fm *frontmatter.Frontmatter, loc *time.Location) Task {
+	task := Task{Summary: fm.GetString("title")}
+	if task.Summary == "" {
+		task.Summary = fm.GetString(summaryKey)
+	}
+	if task.Summary == "" {
+		task.Summary = templates.Title(record.GetString("path"))
+	}
+	value := fm.GetString(dueKey)
+	if due, ok := utils.ParseDate(value, loc); ok {
+		task.Due, task.DueAllDay = due, len(strings.TrimSpace(value)) == len(time.DateOnly)
+	}
+	if completed, ok := utils.ParseDate(fm.GetString(completedKey), loc); ok {
+		task.Completed = completed
+	}
+	task.Priority = parsePriority(fm.GetString(priorityKey))
+	return task
+}
+
+func checkboxTask(m []string, modified time.Time, loc *time.Location) Task {
+	description := m[4]
+	task := Task{
+		Summary:   checkboxSummary(description),
+		DueAllDay: true,
+		Cancelled: m[2] == "-",
+	}
+	for _, d := range taskDateRe.FindAllStringSubmatch(description, -1) {
+		t, err := time.ParseInLocation(time.DateOnly, d[2], loc)
+		if err != nil {
+			continue
+		}
+		switch d[1] {
+		case "📅":
+			task.Due = t
+		case "✅":
+			task.Completed = t
+		}
+	}
+	if task.Completed.IsZero() && (m[2] == "x" || m[2] == "X") {
+		// checked without a done date
+		task.Completed = modified
+	}
+	if p := priorityRe.FindStringSubmatch(description); p != nil {
+		for _, priority := range priorities {
+			if priority.Marker == p[1] {
+				task.Priority = priority.Value
+			}
+		}
+	}
+	return task
+}
+
+// checkboxSummary is the description of a checkbox task without the markers.
+func checkboxSummary(description string) string {
+	for _, re := range []*regexp.Regexp{taskDateRe, taskIdRe, taskRepeatRe, taskDependRe, priorityRe} {
+		description = re.ReplaceAllString(description, "")
+	}
+	return strings.TrimSpace(description)
+}
+
+// checkboxId is the 🆔 of a checkbox task or the hash of its summary, the id
+// is added to the task, when a client changes its summary, so that the task
+// keeps its resource name.
+func checkboxId(description string) string {
+	if m := taskIdRe.FindStringSubmatch(description); m != nil {
+		return m[1]
+	}
+	sum := sha256.Sum256([]byte(checkboxSummary(description)))
+	return hex.EncodeToString(sum[:4])
+}
+
+// checkboxLine writes a task back to a checkbox line, the markers, which are
+// not part of a VTODO, are kept. When only the status has changed, the rest
+// of the line is left as is, so that recurring tasks are still recognized
+// when they are completed.
+func checkboxLine(m []string, id string, task Task, loc *time.Location) string {
+	description := m[4]
+	status := " "
+	switch {
+	case task.Cancelled:
+		status = "-"
+	case !task.Completed.IsZero():
+		status = "x"
+	case m[2] != "x" && m[2] != "X" && m[2] != "-":
+		status = m[2]
+	}
+
+	current := checkboxTask(m, time.Time{}, loc)
+	if current.Summary == task.Summary && current.Priority == task.Priority &&
+		current.Due.Format(time.DateOnly) == task.Due.In(loc).Format(time.DateOnly) {
+		line := m[1] + status + m[3] + strings.TrimSpace(taskDoneRe.ReplaceAllString(description, ""))
+		if status == "x" {
+			line += " ✅ " + task.Completed.In(loc).Format(time.DateOnly)
+		}
+		return line
+	}
+
+	parts := []string{task.Summary}
+	if task.Priority > 0 {
+		parts = append(parts, priorities[priorityIndex(task.Priority)].Marker)
+	}
+	if repeat := taskRepeatRe.FindString(description); repeat != "" {
+		parts = append(parts, strings.TrimSpace(repeat))
+	}
+	for _, d := range taskDateRe.FindAllStringSubmatch(description, -1) {
+		if d[1] == "➕" || d[1] == "🛫" || d[1] == "⏳" {
+			parts = append(parts, d[1]+" "+d[2])
+		}
+	}
+	if !task.Due.IsZero() {
+		parts = append(parts, "📅 "+task.Due.In(loc).Format(time.DateOnly))
+	}
+	if status == "x" {
+		parts = append(parts, "✅ "+task.Completed.In(loc).Format(time.DateOnly))
+	}
+	if depend := taskDependRe.FindString(description); depend != "" {
+		parts = append(parts, strings.TrimSpace(depend))
+	}
+	// the id is only needed, when the summary, which it is derived from,
+	// changes
+	if taskIdRe.MatchString(description) || current.Summary != task.Summary {
+		parts = append(parts, "🆔 "+id)
+	}
+	return m[1] + status + m[3] + strings.Join(parts, " ")
+}
+
+// applyTask writes a task to the frontmatter of a task note.
+func applyTask(fm *frontmatter.Frontmatter, task Task, loc *time.Location) {
+	if _, ok := fm.Get("title"); ok {
+		fm.Set("title", task.Summary)
+	} else {
+		fm.Set(summaryKey, task.Summary)
+	}
+	switch {
+	case task.Due.IsZero():
+		fm.Delete(dueKey)
+	case task.DueAllDay:
+		fm.Set(dueKey, task.Due.Format(time.DateOnly))
+	default:
+		fm.Set(dueKey, task.Due.In(loc).Format("2006-01-02T15:04"))
+	}
+	completed := ""
+	if !task.Completed.IsZero() {
+		completed = task.Completed.In(loc).Format("2006-01-02T15:04:05")
+	}
+	fm.Set(completedKey, completed)
+	if task.Priority > 0 {
+		fm.Set(priorityKey, task.Priority)
+	} else {
+		fm.Delete(priorityKey)
+	}
+}
+
+func todo(record *core.Record, task Task, uid, baseURL string) ical.Component {
+	path := record.GetString("path")
+	noteURL := strings.TrimSuffix(baseURL, "/") + "/items/" + url.PathEscape(record.Id)
+	modified := record.GetDateTime("updated").Time()
+
+	todo := ical.Component{Name: "VTODO"}
+	todo.Add("UID", uid)
+	todo.Add("DTSTAMP", ical.DateTime(modified))
+	todo.Add("LAST-MODIFIED", ical.DateTime(modified))
+	todo.Add("SUMMARY", ical.Text(task.Summary))
+	if !task.Due.IsZero() {
+		if task.DueAllDay {
+			todo.Add("DUE", ical.Date(task.Due), ical.Param{Name: "VALUE", Value: "DATE"})
+		} else {
+			todo.Add("DUE", ical.DateTime(task.Due))
+		}
+	}
+	switch {
+	case task.Cancelled:
+		todo.Add("STATUS", "CANCELLED")
+	case !task.Completed.IsZero():
+		todo.Add("STATUS", "COMPLETED")
+		todo.Add("COMPLETED", ical.DateTime(task.Completed))
+	default:
+		todo.Add("STATUS", "NEEDS-ACTION")
+	}
+	if task.Priority > 0 {
+		todo.Add("PRIORITY", strconv.Itoa(task.Priority))
+	}
+	todo.Add("DESCRIPTION", ical.Text(path+"\n"+noteURL))
+	todo.Add("URL", noteURL, ical.Param{Name: "VALUE", Value: "URI"})
+	return todo
+}
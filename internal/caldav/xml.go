@@ -0,0 +1,155 @@
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const (
+	nsDAV    = "DAV:"
+	nsCalDAV = "urn:ietf:params:xml:ns:caldav"
+	nsCS     = "http://calendarserver.org/ns/"
+)
+
+// prefixes are used for the namespaces of the responses.
+var prefixes = map[string]string{nsDAV: "D", nsCalDAV: "C", nsCS: "CS"}
+
+type anyElement struct {
+	XMLName xml.Name
+}
+
+type propList struct {
+	Props []anyElement `xml:",any"`
+}
+
+type propfindRequest struct {
+	XMLName xml.Name  `xml:"DAV: propfind"`
+	Prop    *propList `xml:"DAV: prop"`
+}
+
+type compFilter struct {
+	Name        string       `xml:"name,attr"`
+	CompFilters []compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	TimeRange   *timeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+// timeRange is in UTC, either of the bounds can be missing.
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// reportRequest covers the bodies of the supported REPORTs, XMLName tells
+// them apart.
+type reportRequest struct {
+	XMLName   xml.Name
+	Prop      *propList   `xml:"DAV: prop"`
+	Hrefs     []string    `xml:"DAV: href"`
+	Filter    *compFilter `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter"`
+	SyncToken string      `xml:"DAV: sync-token"`
+}
+
+// requested returns the names of the requested properties, nil means all of
+// them (allprop or an empty body).
+func (p *propList) requested() []xml.Name {
+	if p == nil {
+		return nil
+	}
+	names := []xml.Name{}
+	for _, prop := range p.Props {
+		names = append(names, prop.XMLName)
+	}
+	return names
+}
+
+func parseBody(body io.Reader, v any) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// props maps the properties of a resource to their XML values.
+type props map[xml.Name]string
+
+func escape(text string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
+
+func hrefXML(href string) string {
+	return "<D:href>" + escape(href) + "</D:href>"
+}
+
+func tag(name xml.Name) string {
+	prefix, ok := prefixes[name.Space]
+	if !ok {
+		return name.Local
+	}
+	return prefix + ":" + name.Local
+}
+
+// multistatus writes the WebDAV multistatus responses.
+type multistatus struct {
+	b strings.Builder
+	// syncToken is written after the responses of a sync-collection
+	syncToken string
+}
+
+func newMultistatus() *multistatus {
+	m := &multistatus{}
+	m.b.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	m.b.WriteString(`<D:multistatus xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav" xmlns:CS="http://calendarserver.org/ns/">`)
+	return m
+}
+
+// add writes the requested properties of a resource, the unknown ones are
+// reported as not found.
+func (m *multistatus) add(href string, values props, requested []xml.Name) {
+	m.b.WriteString("<D:response>" + hrefXML(href))
+	var found, missing strings.Builder
+	if requested == nil {
+		for name, value := range values {
+			found.WriteString("<" + tag(name) + ">" + value + "</" + tag(name) + ">")
+		}
+	}
+	for _, name := range requested {
+		value, ok := values[name]
+		if !ok {
+			// unknown namespaces are written with their own declaration
+			if _, known := prefixes[name.Space]; !known && name.Space != "" {
+				missing.WriteString("<" + name.Local + ` xmlns="` + escape(name.Space) + `"/>`)
+				continue
+			}
+			missing.WriteString("<" + tag(name) + "/>")
+			continue
+		}
+		found.WriteString("<" + tag(name) + ">" + value + "</" + tag(name) + ">")
+	}
+	if found.Len() > 0 {
+		m.b.WriteString("<D:propstat><D:prop>" + found.String() + "</D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>")
+	}
+	if missing.Len() > 0 {
+		m.b.WriteString("<D:propstat><D:prop>" + missing.String() + "</D:prop><D:status>HTTP/1.1 404 Not Found</D:status></D:propstat>")
+	}
+	m.b.WriteString("</D:response>")
+}
+
+// notFound writes a response of a resource, which does not exist.
+func (m *multistatus) notFound(href string) {
+	m.b.WriteString("<D:response>" + hrefXML(href) + "<D:status>HTTP/1.1 404 Not Found</D:status></D:response>")
+}
+
+func (m *multistatus) String() string {
+	if m.syncToken != "" {
+		return m.b.String() + "<D:sync-token>" + escape(m.syncToken) + "</D:sync-token></D:multistatus>"
+	}
+	return m.b.String() + "</D:multistatus>"
+}
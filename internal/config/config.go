@@ -4,26 +4,193 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"time"
 
 	"github.com/goccy/go-yaml"
 )
 
 type NotebaseConfig struct {
-	ClearOnStartup bool     `yaml:"clear_on_startup"`
-	Exclude        []string `yaml:"exclude"`
-	SyncWorkers    int      `yaml:"sync_workers"`
-	SyncBatchSize  int      `yaml:"sync_batch_size"`
+	ClearOnStartup bool            `yaml:"clear_on_startup"`
+	Exclude        []string        `yaml:"exclude"`
+	SyncWorkers    int             `yaml:"sync_workers"`
+	SyncBatchSize  int             `yaml:"sync_batch_size"`
+	Query          QueryConfig     `yaml:"query"`
+	Views          []ViewConfig    `yaml:"views"`
+	Types          []TypeConfig    `yaml:"types"`
+	Revisions      RevisionConfig  `yaml:"revisions"`
+	Git            GitConfig       `yaml:"git"`
+	Templates      TemplatesConfig `yaml:"templates"`
+	Periodic       PeriodicConfig  `yaml:"periodic"`
+	Tracker        TrackerConfig   `yaml:"tracker"`
+	Debts          DebtsConfig     `yaml:"debts"`
+	Groceries      GroceriesConfig `yaml:"groceries"`
+	Caldav         CaldavConfig    `yaml:"caldav"`
+}
+
+type QueryConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+	MaxRows int           `yaml:"max_rows"`
+}
+
+// RevisionConfig is the thinning policy of the note history. Every revision
+// newer than KeepAll is kept, then one per day until KeepDaily, one per week
+// until KeepWeekly and one per month after that. Revisions older than MaxAge
+// are removed, except the latest revision of a note. Zero MaxAge keeps them forever.
+type RevisionConfig struct {
+	KeepAll    time.Duration `yaml:"keep_all"`
+	KeepDaily  time.Duration `yaml:"keep_daily"`
+	KeepWeekly time.Duration `yaml:"keep_weekly"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	// Schedule is a cron expression of the thinning job.
+	Schedule string `yaml:"schedule"`
+}
+
+// GitConfig enables automatic commits of the changed notes to the local
+// repository of the vault. Changes are committed after QuietPeriod without
+// new changes.
+type GitConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	QuietPeriod time.Duration `yaml:"quiet_period"`
+	// Author is used for the commits in the `Name <email>` format.
+	Author string `yaml:"author"`
+}
+
+// TemplatesConfig mirrors the settings of the Obsidian core Templates plugin.
+// Formats use the Moment.js syntax.
+type TemplatesConfig struct {
+	Folder     string `yaml:"folder"`
+	DateFormat string `yaml:"date_format"`
+	TimeFormat string `yaml:"time_format"`
+}
+
+// PeriodicConfig mirrors the Obsidian Periodic Notes plugin. Periods are
+// computed in Timezone, the local timezone is used when it is empty.
+type PeriodicConfig struct {
+	Timezone string `yaml:"timezone"`
+	// CreateAtMidnight creates the notes of the enabled periods, when a
+	// period starts.
+	CreateAtMidnight bool         `yaml:"create_at_midnight"`
+	Daily            PeriodConfig `yaml:"daily"`
+	Weekly           PeriodConfig `yaml:"weekly"`
+	Monthly          PeriodConfig `yaml:"monthly"`
+}
+
+// PeriodConfig describes where the notes of a period are. Format is
+// a Moment.js format of the file name and may contain folders, Template is
+// a name in the templates folder.
+type PeriodConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Folder   string `yaml:"folder"`
+	Format   string `yaml:"format"`
+	Template string `yaml:"template"`
+}
+
+// TrackerConfig configures the episode tracking actions. Cadence is a
+// recurrence rule, e.g. `every week`, which moves next_episode forward,
+// notes override it with their own `cadence`.
+type TrackerConfig struct {
+	Type    string `yaml:"type"`
+	Cadence string `yaml:"cadence"`
+}
+
+// DebtsConfig configures the plain-text accounting export. Every debt note
+// is a sub-account of Account, unless it sets its own `account`, and Offset
+// balances the transactions.
+type DebtsConfig struct {
+	Account string `yaml:"account"`
+	Offset  string `yaml:"offset"`
+}
+
+// GroceriesConfig configures the groceries lists, new lists are created in
+// Folder. Completed lists are moved to ArchiveFolder, into a subfolder
+// named with the Moment.js ArchiveFormat, e.g. `YYYY-MM`.
+type GroceriesConfig struct {
+	Folder        string `yaml:"folder"`
+	ArchiveFolder string `yaml:"archive_folder"`
+	ArchiveFormat string `yaml:"archive_format"`
+}
+
+// CaldavConfig configures the calendar, which is generated from the notes.
+// URL is the public URL of the web UI, which the events link to, the URL of
+// the request is used when it is empty. Only superusers can sign in, unless
+// the emails of verified users are listed in Users.
+type CaldavConfig struct {
+	Name   string              `yaml:"name"`
+	URL    string              `yaml:"url"`
+	Users  []string            `yaml:"users"`
+	Events []CaldavEventConfig `yaml:"events"`
+	Tasks  CaldavTasksConfig   `yaml:"tasks"`
+}
+
+// CaldavEventConfig turns a date property of the notes into an event, which
+// lasts until the End property, when it is set. Type limits it to the notes
+// of a type.
+type CaldavEventConfig struct {
+	Type  string `yaml:"type"`
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// CaldavTasksConfig configures the VTODOs. Notes of Types are tasks, the
+// checkbox tasks are read from the notes in Checkboxes folders and the tasks
+// added by the clients are created in Folder.
+type CaldavTasksConfig struct {
+	Types      []string `yaml:"types"`
+	Checkboxes []string `yaml:"checkboxes"`
+	Folder     string   `yaml:"folder"`
+}
+
+// ViewConfig describes a PocketBase view collection over the files table.
+// Either Query is set to a raw SQL statement, or the view is generated
+// from Folder, Where and Fields.
+type ViewConfig struct {
+	Name   string         `yaml:"name"`
+	Query  string         `yaml:"query"`
+	Folder string         `yaml:"folder"`
+	Where  map[string]any `yaml:"where"`
+	Fields []string       `yaml:"fields"`
+}
+
+// TypeConfig describes the frontmatter of the notes with a matching `type` key.
+type TypeConfig struct {
+	Name   string        `yaml:"name" json:"name"`
+	Fields []FieldConfig `yaml:"fields" json:"fields"`
+}
+
+// FieldConfig is a single frontmatter key. Items describes list elements
+// and Fields describes the keys of an object.
+type FieldConfig struct {
+	Name     string        `yaml:"name" json:"name"`
+	Type     string        `yaml:"type" json:"type"`
+	Required bool          `yaml:"required" json:"required"`
+	Enum     []any         `yaml:"enum" json:"enum,omitempty"`
+	Default  any           `yaml:"default" json:"default,omitempty"`
+	Items    *FieldConfig  `yaml:"items" json:"items,omitempty"`
+	Fields   []FieldConfig `yaml:"fields" json:"fields,omitempty"`
+}
+
+// Location is the timezone of the dates, which are written without one. It
+// is the timezone of the periodic notes, Load makes sure that it is valid.
+func (c *NotebaseConfig) Location() *time.Location {
+	if c.Periodic.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Periodic.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
 }
 
 func Load(root string) (NotebaseConfig, error) {
 	conf := NotebaseConfig{}
 	data, err := os.ReadFile(path.Join(root, ".notebase.yml"))
 	if err != nil {
-		return conf, fmt.Errorf("error reading config", err)
+		return conf, fmt.Errorf("error reading config: %w", err)
 	}
 	err = yaml.Unmarshal(data, &conf)
 	if err != nil {
-		return conf, fmt.Errorf("error parsing config", err)
+		return conf, fmt.Errorf("error parsing config: %w", err)
 	}
 	if conf.SyncBatchSize == 0 {
 		conf.SyncBatchSize = 200
@@ -31,5 +198,93 @@ func Load(root string) (NotebaseConfig, error) {
 	if conf.SyncWorkers == 0 {
 		conf.SyncWorkers = 5
 	}
+	if conf.Query.Timeout == 0 {
+		conf.Query.Timeout = 5 * time.Second
+	}
+	if conf.Query.MaxRows == 0 {
+		conf.Query.MaxRows = 1000
+	}
+	if conf.Revisions.KeepAll == 0 {
+		conf.Revisions.KeepAll = 7 * 24 * time.Hour
+	}
+	if conf.Revisions.KeepDaily == 0 {
+		conf.Revisions.KeepDaily = 30 * 24 * time.Hour
+	}
+	if conf.Revisions.KeepWeekly == 0 {
+		conf.Revisions.KeepWeekly = 180 * 24 * time.Hour
+	}
+	if conf.Revisions.Schedule == "" {
+		conf.Revisions.Schedule = "0 4 * * *"
+	}
+	if conf.Git.QuietPeriod == 0 {
+		conf.Git.QuietPeriod = 30 * time.Second
+	}
+	if conf.Git.Author == "" {
+		conf.Git.Author = "notebase <notebase@localhost>"
+	}
+	if conf.Templates.Folder == "" {
+		conf.Templates.Folder = "templates"
+	}
+	if conf.Templates.DateFormat == "" {
+		conf.Templates.DateFormat = "YYYY-MM-DD"
+	}
+	if conf.Templates.TimeFormat == "" {
+		conf.Templates.TimeFormat = "HH:mm"
+	}
+	if conf.Tracker.Type == "" {
+		conf.Tracker.Type = "track"
+	}
+	if conf.Tracker.Cadence == "" {
+		conf.Tracker.Cadence = "every week"
+	}
+	if conf.Periodic.Timezone != "" {
+		if _, err := time.LoadLocation(conf.Periodic.Timezone); err != nil {
+			return conf, fmt.Errorf("invalid periodic notes timezone: %w", err)
+		}
+	}
+	if conf.Debts.Account == "" {
+		conf.Debts.Account = "Assets:Debts"
+	}
+	if conf.Debts.Offset == "" {
+		conf.Debts.Offset = "Assets:Cash"
+	}
+	if conf.Groceries.Folder == "" {
+		conf.Groceries.Folder = "groceries"
+	}
+	if conf.Groceries.ArchiveFolder == "" {
+		conf.Groceries.ArchiveFolder = "groceries/archive"
+	}
+	if conf.Groceries.ArchiveFormat == "" {
+		conf.Groceries.ArchiveFormat = "YYYY-MM"
+	}
+	if conf.Caldav.Name == "" {
+		conf.Caldav.Name = "Notes"
+	}
+	if conf.Caldav.Events == nil {
+		conf.Caldav.Events = []CaldavEventConfig{
+			{Start: "due"},
+			{Start: "next_episode"},
+			{Start: "start", End: "end"},
+			{Start: "date"},
+		}
+	}
+	if conf.Caldav.Tasks.Types == nil {
+		conf.Caldav.Tasks.Types = []string{"task"}
+	}
+	if conf.Caldav.Tasks.Checkboxes == nil {
+		conf.Caldav.Tasks.Checkboxes = []string{"todo"}
+	}
+	if conf.Caldav.Tasks.Folder == "" {
+		conf.Caldav.Tasks.Folder = "todo/tasks"
+	}
+	if conf.Periodic.Daily.Format == "" {
+		conf.Periodic.Daily.Format = "YYYY-MM-DD"
+	}
+	if conf.Periodic.Weekly.Format == "" {
+		conf.Periodic.Weekly.Format = "gggg-[W]ww"
+	}
+	if conf.Periodic.Monthly.Format == "" {
+		conf.Periodic.Monthly.Format = "YYYY-MM"
+	}
 	return conf, nil
 }
@@ -0,0 +1,101 @@
+package debts
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+func (h *DebtsHandler) DebtsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debts",
+		Short: "Export and import the transactions of debt notes",
+	}
+	cmd.AddCommand(h.exportCmd(), h.importCmd())
+	return cmd
+}
+
+func (h *DebtsHandler) exportCmd() *cobra.Command {
+	var (
+		format string
+		output string
+	)
+	cmd := &cobra.Command{
+		Use:          "export",
+		SilenceUsage: true,
+		Short:        "Print the transactions as an hledger or beancount journal",
+		Example: `  notebase debts export > debts.journal
+  notebase debts export --format beancount --output debts.beancount`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var w io.Writer = cmd.OutOrStdout()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+			return h.Export(w, format)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", FormatHledger, "hledger or beancount")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "write the journal to a file")
+	return cmd
+}
+
+func (h *DebtsHandler) importCmd() *cobra.Command {
+	var (
+		format string
+		apply  bool
+	)
+	cmd := &cobra.Command{
+		Use:          "import <journal>",
+		SilenceUsage: true,
+		Short:        "Append journal transactions to the matching debt notes (dry run by default)",
+		Example: `  notebase debts import books.journal
+  notebase debts import books.beancount --apply`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format == "" {
+				format = FormatHledger
+				switch filepath.Ext(args[0]) {
+				case ".beancount", ".bean":
+					format = FormatBeancount
+				}
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+			result, err := h.Import(f, format, !apply)
+			if apply {
+				h.committer.CommitPending()
+			}
+			if err != nil {
+				return err
+			}
+			for _, e := range result.Added {
+				cmd.Printf("+ %s %s %g %s %q\n", e.Path, e.Created, e.Amount, e.Currency, e.Comment)
+			}
+			for _, line := range result.Unmatched {
+				cmd.PrintErrf("no debt note for %s\n", line)
+			}
+			verb := "would add"
+			if apply {
+				verb = "added"
+			}
+			cmd.Printf("%s %d, skipped %d existing, %d unmatched\n", verb, len(result.Added), result.Skipped, len(result.Unmatched))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "", "hledger or beancount, guessed from the extension by default")
+	cmd.Flags().BoolVar(&apply, "apply", false, "write the transactions, otherwise only print them")
+	return cmd
+}
@@ -0,0 +1,416 @@
+package debts
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const debtType = "debt"
+
+// dateLayout is what the web UI writes into `created` of the transactions.
+const dateLayout = "2006-01-02T15:04:05"
+
+// Committer commits the notes changed by the CLI to git.
+type Committer interface {
+	CommitPending()
+}
+
+type DebtsHandler struct {
+	app          *pocketbase.PocketBase
+	conf         *config.NotebaseConfig
+	committer    Committer
+	transactions *Transactions[Balance]
+}
+
+type Transaction struct {
+	Index   int     `json:"index"`
+	Amount  float64 `json:"amount"`
+	Comment string  `json:"comment"`
+	Created string  `json:"created"`
+}
+
+// Balance is the state of a single debt note. Positive balances are owed
+// to the owner of the vault, the way the web UI shows them.
+type Balance struct {
+	Id           string `json:"id"`
+	Path         string `json:"path"`
+	Summary      string `json:"summary"`
+	Counterparty string `json:"counterparty"`
+	Currency     string `json:"currency"`
+	// Account overrides the account of the note in the journal export.
+	Account      string        `json:"account,omitempty"`
+	Balance      float64       `json:"balance"`
+	Completed    bool          `json:"completed"`
+	Transactions []Transaction `json:"transactions,omitempty"`
+}
+
+type Total struct {
+	Counterparty string  `json:"counterparty,omitempty"`
+	Currency     string  `json:"currency"`
+	Balance      float64 `json:"balance"`
+	Notes        int     `json:"notes"`
+}
+
+type Summary struct {
+	Notes          []Balance `json:"notes"`
+	Currencies     []Total   `json:"currencies"`
+	Counterparties []Total   `json:"counterparties"`
+}
+
+type Point struct {
+	Date    string  `json:"date"`
+	Balance float64 `json:"balance"`
+}
+
+// TransactionInput is the body of the transaction endpoints, missing fields
+// are kept when a transaction is edited.
+type TransactionInput struct {
+	Amount  *float64 `json:"amount"`
+	Comment *string  `json:"comment"`
+	Created *string  `json:"created"`
+}
+
+var (
+	ErrNotDebt             = errors.New("note is not a debt")
+	ErrTransactionNotFound = errors.New("transaction not found")
+)
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig, committer Committer) *DebtsHandler {
+	return &DebtsHandler{
+		app:       app,
+		conf:      conf,
+		committer: committer,
+		transactions: &Transactions[Balance]{
+			App:        app,
+			Type:       debtType,
+			Key:        "transactions",
+			Item:       "transaction",
+			ErrNotType: ErrNotDebt,
+			Location:   conf.Location(),
+			View:       balanceOf,
+		},
+	}
+}
+
+func (h *DebtsHandler) records(fileId string) ([]*core.Record, error) {
+	if fileId != "" {
+		record, err := h.app.FindRecordById("files", fileId)
+		if err != nil || record.GetString("deleted") != "" {
+			return nil, errors.New("file not found")
+		}
+		return []*core.Record{record}, nil
+	}
+	return h.app.FindRecordsByFilter("files", "frontmatter.type = 'debt' && deleted = ''", "path", 0, 0)
+}
+
+func balanceOf(record *core.Record) (Balance, error) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return Balance{}, err
+	}
+	if fm.GetString("type") != debtType {
+		return Balance{}, ErrNotDebt
+	}
+	balance := Balance{
+		Id:           record.Id,
+		Path:         record.GetString("path"),
+		Summary:      fm.GetString("summary"),
+		Counterparty: fm.GetString("counterparty"),
+		Currency:     fm.GetString("currency"),
+		Account:      fm.GetString("account"),
+		Completed:    fm.GetString("completed") != "",
+		Transactions: []Transaction{},
+	}
+	items, _ := fm.Map()["transactions"].([]any)
+	for i, item := range items {
+		values, _ := item.(map[string]any)
+		amount, _ := values["amount"].(float64)
+		comment, _ := values["comment"].(string)
+		created, _ := values["created"].(string)
+		balance.Transactions = append(balance.Transactions, Transaction{Index: i, Amount: amount, Comment: comment, Created: created})
+		balance.Balance += amount
+	}
+	balance.Balance = utils.Round(balance.Balance)
+	return balance, nil
+}
+
+// Summarize returns the balance of every debt note and the totals per
+// currency and per counterparty.
+func (h *DebtsHandler) Summarize() (Summary, error) {
+	summary := Summary{Notes: []Balance{}, Currencies: []Total{}, Counterparties: []Total{}}
+	records, err := h.records("")
+	if err != nil {
+		return summary, err
+	}
+	currencies := map[string]*Total{}
+	counterparties := map[[2]string]*Total{}
+	for _, record := range records {
+		balance, err := balanceOf(record)
+		if err != nil {
+			continue
+		}
+		balance.Transactions = nil
+		summary.Notes = append(summary.Notes, balance)
+
+		if currencies[balance.Currency] == nil {
+			currencies[balance.Currency] = &Total{Currency: balance.Currency}
+		}
+		currencies[balance.Currency].Balance += balance.Balance
+		currencies[balance.Currency].Notes++
+		key := [2]string{balance.Counterparty, balance.Currency}
+		if counterparties[key] == nil {
+			counterparties[key] = &Total{Counterparty: balance.Counterparty, Currency: balance.Currency}
+		}
+		counterparties[key].Balance += balance.Balance
+		counterparties[key].Notes++
+	}
+	for _, total := range currencies {
+		total.Balance = utils.Round(total.Balance)
+		summary.Currencies = append(summary.Currencies, *total)
+	}
+	for _, total := range counterparties {
+		total.Balance = utils.Round(total.Balance)
+		summary.Counterparties = append(summary.Counterparties, *total)
+	}
+	sort.Slice(summary.Currencies, func(i, j int) bool {
+		return summary.Currencies[i].Currency < summary.Currencies[j].Currency
+	})
+	sort.Slice(summary.Counterparties, func(i, j int) bool {
+		a, b := summary.Counterparties[i], summary.Counterparties[j]
+		if a.Counterparty != b.Counterparty {
+			return a.Counterparty < b.Counterparty
+		}
+		return a.Currency < b.Currency
+	})
+	return summary, nil
+}
+
+// Series returns the running balance per currency at the end of every day,
+// week or month with transactions. fileId limits it to a single note.
+func (h *DebtsHandler) Series(fileId, currency, interval string) (map[string][]Point, error) {
+	records, err := h.records(fileId)
+	if err != nil {
+		return nil, err
+	}
+	type entry struct {
+		at     time.Time
+		amount float64
+	}
+	entries := map[string][]entry{}
+	for _, record := range records {
+		balance, err := balanceOf(record)
+		if err != nil {
+			if fileId != "" {
+				return nil, err
+			}
+			continue
+		}
+		if currency != "" && balance.Currency != currency {
+			continue
+		}
+		for _, t := range balance.Transactions {
+			at, ok := utils.ParseDate(t.Created, h.conf.Location())
+			if !ok {
+				continue
+			}
+			entries[balance.Currency] = append(entries[balance.Currency], entry{at, t.Amount})
+		}
+	}
+
+	series := map[string][]Point{}
+	for cur, list := range entries {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].at.Before(list[j].at) })
+		points := []Point{}
+		total := 0.0
+		for _, e := range list {
+			total += e.amount
+			date := bucket(e.at, interval)
+			if len(points) > 0 && points[len(points)-1].Date == date {
+				points[len(points)-1].Balance = utils.Round(total)
+				continue
+			}
+			points = append(points, Point{Date: date, Balance: utils.Round(total)})
+		}
+		series[cur] = points
+	}
+	return series, nil
+}
+
+func bucket(t time.Time, interval string) string {
+	switch interval {
+	case "week":
+		return t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7)).Format(time.DateOnly)
+	case "month":
+		return t.Format("2006-01")
+	}
+	return t.Format(time.DateOnly)
+}
+
+// Validate checks a transaction before it is written, created defaults to now.
+// Dates without a timezone are interpreted in loc.
+func (in *TransactionInput) Validate(partial bool, loc *time.Location) error {
+	errs := validation.Errors{}
+	if in.Amount == nil {
+		if !partial {
+			errs["amount"] = validation.NewError("validation_required", "amount is required")
+		}
+	} else if *in.Amount == 0 || math.IsNaN(*in.Amount) || math.IsInf(*in.Amount, 0) {
+		errs["amount"] = validation.NewError("validation_invalid_amount", "amount must be a non-zero number")
+	}
+	if in.Created == nil && !partial {
+		now := time.Now().In(loc).Format(dateLayout)
+		in.Created = &now
+	}
+	if in.Created != nil {
+		if _, ok := utils.ParseDate(*in.Created, loc); !ok {
+			errs["created"] = validation.NewError("validation_invalid_date", "created must be a date, e.g. 2025-05-06T10:00:00")
+		}
+	}
+	if in.Comment != nil && len([]rune(*in.Comment)) > 256 {
+		errs["comment"] = validation.NewError("validation_too_long", "comment is too long")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Change edits the transactions of a debt note.
+func (h *DebtsHandler) Change(record *core.Record, index int, in *TransactionInput) error {
+	return h.transactions.Change(record, index, in)
+}
+
+// EditTransactions edits a list of transactions under the key, other note
+// types store their amounts the same way. An index of -1 appends
+// a transaction, a nil input removes it.
+func EditTransactions(fm *frontmatter.Frontmatter, key string, index int, in *TransactionInput) error {
+	value, _ := fm.Get(key)
+	transactions, _ := value.([]any)
+	if index >= len(transactions) || index < -1 || (in == nil && index < 0) {
+		return ErrTransactionNotFound
+	}
+
+	switch {
+	case in == nil:
+		transactions = append(transactions[:index], transactions[index+1:]...)
+	case index == -1:
+		item := yaml.MapSlice{{Key: "amount", Value: utils.Number(*in.Amount)}}
+		if in.Comment != nil {
+			item = append(item, yaml.MapItem{Key: "comment", Value: *in.Comment})
+		}
+		transactions = append(transactions, append(item, yaml.MapItem{Key: "created", Value: *in.Created}))
+	default:
+		item, ok := transactions[index].(yaml.MapSlice)
+		if !ok {
+			return errors.New("transaction is not an object")
+		}
+		set := func(key string, value any) {
+			for i := range item {
+				if item[i].Key == key {
+					item[i].Value = value
+					return
+				}
+			}
+			item = append(item, yaml.MapItem{Key: key, Value: value})
+		}
+		if in.Amount != nil {
+			set("amount", utils.Number(*in.Amount))
+		}
+		if in.Comment != nil {
+			set("comment", *in.Comment)
+		}
+		if in.Created != nil {
+			set("created", *in.Created)
+		}
+		transactions[index] = item
+	}
+	fm.Set(key, transactions)
+	return nil
+}
+
+func (h *DebtsHandler) Routes(se *core.ServeEvent) {
+	debtsGroup := se.Router.Group("/debts")
+	debtsGroup.Bind(apis.RequireSuperuserAuth())
+	debtsGroup.GET("", func(e *core.RequestEvent) error {
+		summary, err := h.Summarize()
+		if err != nil {
+			return apis.NewBadRequestError("unable to summarize debts", err)
+		}
+		return e.JSON(http.StatusOK, summary)
+	})
+	debtsGroup.GET("/series", func(e *core.RequestEvent) error {
+		query := e.Request.URL.Query()
+		interval := query.Get("interval")
+		if interval != "" && interval != "day" && interval != "week" && interval != "month" {
+			return apis.NewBadRequestError("interval must be day, week or month", nil)
+		}
+		series, err := h.Series(query.Get("file"), query.Get("currency"), interval)
+		if err != nil {
+			return apis.NewNotFoundError("debt not found", nil)
+		}
+		return e.JSON(http.StatusOK, series)
+	})
+	debtsGroup.GET("/export", func(e *core.RequestEvent) error {
+		format := e.Request.URL.Query().Get("format")
+		if format == "" {
+			format = FormatHledger
+		}
+		var b strings.Builder
+		if err := h.Export(&b, format); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.String(http.StatusOK, b.String())
+	})
+	debtsGroup.POST("/import", func(e *core.RequestEvent) error {
+		req := struct {
+			Journal string `json:"journal"`
+			Format  string `json:"format"`
+			Apply   bool   `json:"apply"`
+		}{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		if req.Format == "" {
+			req.Format = FormatHledger
+		}
+		// like the CLI, the import is a dry run unless it is applied
+		result, err := h.Import(strings.NewReader(req.Journal), req.Format, !req.Apply)
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, result)
+	})
+	debtsGroup.GET("/{id}", func(e *core.RequestEvent) error {
+		record, err := h.debt(e)
+		if err != nil {
+			return err
+		}
+		balance, err := balanceOf(record)
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, balance)
+	})
+	h.transactions.Routes(debtsGroup)
+}
+
+func (h *DebtsHandler) debt(e *core.RequestEvent) (*core.Record, error) {
+	record, err := h.app.FindRecordById("files", e.Request.PathValue("id"))
+	if err != nil || record.GetString("deleted") != "" {
+		return nil, apis.NewNotFoundError("file not found", nil)
+	}
+	return record, nil
+}
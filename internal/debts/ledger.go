@@ -0,0 +1,464 @@
+package debts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	FormatHledger   = "hledger"
+	FormatBeancount = "beancount"
+)
+
+// Entry is a transaction of a journal, which belongs to a debt note.
+type Entry struct {
+	Path     string  `json:"path"`
+	Account  string  `json:"account"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+	Comment  string  `json:"comment"`
+	Created  string  `json:"created"`
+}
+
+type ImportResult struct {
+	Added     []Entry  `json:"added"`
+	Skipped   int      `json:"skipped"`
+	Unmatched []string `json:"unmatched"`
+}
+
+// journalTransaction is a parsed transaction of either format.
+type journalTransaction struct {
+	line        int
+	date        string
+	description string
+	tags        map[string]string
+	postings    []posting
+}
+
+type posting struct {
+	account   string
+	amount    float64
+	commodity string
+	hasAmount bool
+}
+
+var (
+	hledgerHeaderRe   = regexp.MustCompile(`^(\d{4}[-/.]\d{2}[-/.]\d{2})(?:=\S+)?(?:\s+[*!])?(?:\s+\([^)]*\))?\s*(.*)$`)
+	beancountHeaderRe = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2})\s+(?:\*|!|txn)\s*(.*)$`)
+	beancountMetaRe   = regexp.MustCompile(`^\s+([a-z][\w-]*):\s*(.*)$`)
+	quotedRe          = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"`)
+	amountRe          = regexp.MustCompile(`^(?:([^\d\s.,-]+)\s*)?(-?[\d,]*\.?\d+)(?:\s*([^\d\s;]+))?$`)
+	hledgerTagRe      = regexp.MustCompile(`([\w-]+):\s*((?:[^,\\]|\\.)*)`)
+)
+
+// hledger has no quoting, so the descriptions and the tag values are escaped
+// with backslashes. Newlines would end the transaction, `;` starts the
+// comment and `,` ends a tag value.
+var (
+	hledgerDescriptionEscaper = strings.NewReplacer(`\`, `\\`, "\r", "", "\n", `\n`, ";", `\;`)
+	hledgerTagEscaper         = strings.NewReplacer(`\`, `\\`, "\r", "", "\n", `\n`, ";", `\;`, ",", `\,`)
+)
+
+func hledgerUnescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if s[i] == 'n' {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// cutComment splits a line at the first `;`, which is not escaped.
+func cutComment(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case ';':
+			return line[:i], line[i+1:]
+		}
+	}
+	return line, ""
+}
+
+// accountName is the account of a debt note, names are reduced to letters
+// and digits, which both hledger and beancount accept.
+func (h *DebtsHandler) accountName(record *core.Record, balance Balance) string {
+	if account := strings.TrimSpace(balance.Account); account != "" {
+		return account
+	}
+	name := balance.Counterparty
+	if name == "" {
+		name = balance.Summary
+	}
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !isASCIIAlnum(r) }) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	if b.Len() == 0 {
+		b.WriteString(record.GetString("slug"))
+	}
+	return h.conf.Debts.Account + ":" + b.String()
+}
+
+func isASCIIAlnum(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// Entries returns the transactions of all debt notes ordered by date.
+func (h *DebtsHandler) Entries() ([]Entry, error) {
+	records, err := h.records("")
+	if err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	for _, record := range records {
+		balance, err := balanceOf(record)
+		if err != nil {
+			continue
+		}
+		account := h.accountName(record, balance)
+		for _, t := range balance.Transactions {
+			entries = append(entries, Entry{
+				Path:     balance.Path,
+				Account:  account,
+				Amount:   t.Amount,
+				Currency: balance.Currency,
+				Comment:  t.Comment,
+				Created:  t.Created,
+			})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Created < entries[j].Created })
+	return entries, nil
+}
+
+// Export writes the transactions of all debt notes as a journal. The note
+// path and the original timestamp are kept as tags, so that Import skips
+// exported entries. Beancount requires the accounts to be opened, they are
+// opened at the date of their first transaction.
+func (h *DebtsHandler) Export(w io.Writer, format string) error {
+	if format != FormatHledger && format != FormatBeancount {
+		return fmt.Errorf("unknown format %q, expected hledger or beancount", format)
+	}
+	entries, err := h.Entries()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Currency == "" {
+			return fmt.Errorf("%s has no currency, set `currency` in its frontmatter to export it", e.Path)
+		}
+	}
+	writeJournal(w, entries, format, h.conf.Debts.Offset)
+	return nil
+}
+
+// writeJournal writes the entries, which are ordered by date, against the
+// offset account.
+func writeJournal(w io.Writer, entries []Entry, format, offset string) {
+	if format == FormatBeancount {
+		writeOpenDirectives(w, entries, offset)
+	}
+	for _, e := range entries {
+		date := dateOf(e.Created)
+		amount := strconv.FormatFloat(e.Amount, 'f', -1, 64)
+		negated := strconv.FormatFloat(-e.Amount, 'f', -1, 64)
+		switch format {
+		case FormatBeancount:
+			fmt.Fprintf(w, "%s * %s\n", date, strconv.Quote(e.Comment))
+			fmt.Fprintf(w, "  note: %s\n  created: %s\n", strconv.Quote(e.Path), strconv.Quote(e.Created))
+			fmt.Fprintf(w, "  %s  %s %s\n", e.Account, amount, e.Currency)
+			fmt.Fprintf(w, "  %s  %s %s\n\n", offset, negated, e.Currency)
+		case FormatHledger:
+			fmt.Fprintf(w, "%s %s  ; note:%s, created:%s\n", date,
+				hledgerDescriptionEscaper.Replace(e.Comment),
+				hledgerTagEscaper.Replace(e.Path),
+				hledgerTagEscaper.Replace(e.Created),
+			)
+			fmt.Fprintf(w, "    %s  %s %s\n", e.Account, amount, e.Currency)
+			fmt.Fprintf(w, "    %s\n\n", offset)
+		}
+	}
+}
+
+// writeOpenDirectives opens every account of the entries, which are ordered
+// by date, and the offset account.
+func writeOpenDirectives(w io.Writer, entries []Entry, offset string) {
+	if len(entries) == 0 {
+		return
+	}
+	opened := map[string]string{offset: dateOf(entries[0].Created)}
+	for _, e := range entries {
+		if _, ok := opened[e.Account]; !ok {
+			opened[e.Account] = dateOf(e.Created)
+		}
+	}
+	accounts := make([]string, 0, len(opened))
+	for account := range opened {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		if opened[accounts[i]] != opened[accounts[j]] {
+			return opened[accounts[i]] < opened[accounts[j]]
+		}
+		return accounts[i] < accounts[j]
+	})
+	for _, account := range accounts {
+		fmt.Fprintf(w, "%s open %s\n", opened[account], account)
+	}
+	fmt.Fprintln(w)
+}
+
+func dateOf(created string) string {
+	if len(created) > len(time.DateOnly) {
+		return created[:len(time.DateOnly)]
+	}
+	return created
+}
+
+// Import appends the journal transactions, which post to a debt note
+// account, to the matching notes. Transactions with the same date, amount
+// and comment as an existing one are skipped.
+func (h *DebtsHandler) Import(r io.Reader, format string, dryRun bool) (ImportResult, error) {
+	result := ImportResult{Added: []Entry{}, Unmatched: []string{}}
+	var (
+		transactions []journalTransaction
+		err          error
+	)
+	switch format {
+	case FormatBeancount:
+		transactions, err = parseBeancount(r)
+	case FormatHledger:
+		transactions, err = parseHledger(r)
+	default:
+		return result, fmt.Errorf("unknown format %q, expected hledger or beancount", format)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	records, err := h.records("")
+	if err != nil {
+		return result, err
+	}
+	type note struct {
+		record   *core.Record
+		currency string
+	}
+	notes := map[string]note{}
+	existing := map[string]bool{}
+	for _, record := range records {
+		balance, err := balanceOf(record)
+		if err != nil {
+			continue
+		}
+		notes[h.accountName(record, balance)] = note{record, balance.Currency}
+		for _, t := range balance.Transactions {
+			existing[entryKey(balance.Path, t.Amount, t.Created, t.Comment)] = true
+		}
+	}
+
+	for _, t := range transactions {
+		matched := false
+		for _, p := range t.postings {
+			n, ok := notes[p.account]
+			if !ok {
+				continue
+			}
+			record, currency := n.record, n.currency
+			matched = true
+			if !p.hasAmount {
+				return result, fmt.Errorf("line %d: posting to %s has no amount", t.line, p.account)
+			}
+			if p.commodity != "" && currency != "" && p.commodity != currency {
+				return result, fmt.Errorf("line %d: %s is in %s, expected %s", t.line, p.account, p.commodity, currency)
+			}
+			created := t.tags["created"]
+			if created == "" {
+				created = t.date + "T00:00:00"
+			}
+			entry := Entry{
+				Path:     record.GetString("path"),
+				Account:  p.account,
+				Amount:   p.amount,
+				Currency: currency,
+				Comment:  t.description,
+				Created:  created,
+			}
+			key := entryKey(entry.Path, entry.Amount, entry.Created, entry.Comment)
+			if existing[key] {
+				result.Skipped++
+				continue
+			}
+			existing[key] = true
+			in := &TransactionInput{Amount: &entry.Amount, Comment: &entry.Comment, Created: &entry.Created}
+			if err := in.Validate(false, h.conf.Location()); err != nil {
+				return result, fmt.Errorf("line %d: %w", t.line, err)
+			}
+			if !dryRun {
+				if err := h.Change(record, -1, in); err != nil {
+					return result, fmt.Errorf("line %d: %w", t.line, err)
+				}
+			}
+			result.Added = append(result.Added, entry)
+		}
+		if !matched {
+			result.Unmatched = append(result.Unmatched, fmt.Sprintf("line %d: %s %s", t.line, t.date, t.description))
+		}
+	}
+	return result, nil
+}
+
+// entryKey compares transactions by day, so that imported dates without
+// time match the existing timestamps.
+func entryKey(path string, amount float64, created, comment string) string {
+	return fmt.Sprintf("%s|%s|%g|%s", path, dateOf(created), amount, strings.TrimSpace(comment))
+}
+
+func parseHledger(r io.Reader) ([]journalTransaction, error) {
+	transactions := []journalTransaction{}
+	var current *journalTransaction
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || !unicode.IsSpace(rune(line[0])) {
+			current = nil
+		}
+		if m := hledgerHeaderRe.FindStringSubmatch(line); m != nil {
+			description, comment := cutComment(m[2])
+			transactions = append(transactions, journalTransaction{
+				line:        n,
+				date:        strings.NewReplacer("/", "-", ".", "-").Replace(m[1]),
+				description: hledgerUnescape(strings.TrimSpace(description)),
+				tags:        hledgerTags(comment),
+			})
+			current = &transactions[len(transactions)-1]
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+		text := strings.TrimSpace(line)
+		if strings.HasPrefix(text, ";") {
+			for k, v := range hledgerTags(text[1:]) {
+				current.tags[k] = v
+			}
+			continue
+		}
+		text, _, _ = strings.Cut(text, ";")
+		// the account and the amount are separated by two spaces or a tab
+		account, amount := text, ""
+		if i := strings.IndexAny(text, "\t"); i >= 0 {
+			account, amount = text[:i], text[i+1:]
+		} else if i := strings.Index(text, "  "); i >= 0 {
+			account, amount = text[:i], text[i+2:]
+		}
+		p, err := parsePosting(strings.TrimSpace(account), strings.TrimSpace(amount))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		current.postings = append(current.postings, p)
+	}
+	return transactions, scanner.Err()
+}
+
+func hledgerTags(comment string) map[string]string {
+	tags := map[string]string{}
+	for _, m := range hledgerTagRe.FindAllStringSubmatch(comment, -1) {
+		tags[m[1]] = hledgerUnescape(strings.TrimSpace(m[2]))
+	}
+	return tags
+}
+
+func parseBeancount(r io.Reader) ([]journalTransaction, error) {
+	transactions := []journalTransaction{}
+	var current *journalTransaction
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || !unicode.IsSpace(rune(line[0])) {
+			current = nil
+		}
+		if m := beancountHeaderRe.FindStringSubmatch(line); m != nil {
+			// the narration is the last string, it may be preceded by the payee
+			description := ""
+			if strs := quotedRe.FindAllStringSubmatch(m[2], -1); len(strs) > 0 {
+				description, _ = strconv.Unquote(`"` + strs[len(strs)-1][1] + `"`)
+			}
+			transactions = append(transactions, journalTransaction{
+				line:        n,
+				date:        m[1],
+				description: description,
+				tags:        map[string]string{},
+			})
+			current = &transactions[len(transactions)-1]
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+		text, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		if text == "" {
+			continue
+		}
+		if m := beancountMetaRe.FindStringSubmatch(line); m != nil {
+			value := strings.TrimSpace(m[2])
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			current.tags[m[1]] = value
+			continue
+		}
+		fields := strings.Fields(text)
+		p, err := parsePosting(fields[0], strings.Join(fields[1:], " "))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		current.postings = append(current.postings, p)
+	}
+	return transactions, scanner.Err()
+}
+
+func parsePosting(account, amount string) (posting, error) {
+	p := posting{account: account}
+	// costs and prices are not needed for debts
+	amount, _, _ = strings.Cut(amount, "@")
+	amount, _, _ = strings.Cut(amount, "{")
+	amount = strings.TrimSpace(amount)
+	if amount == "" {
+		return p, nil
+	}
+	m := amountRe.FindStringSubmatch(amount)
+	if m == nil {
+		return p, fmt.Errorf("invalid amount %q", amount)
+	}
+	n, err := strconv.ParseFloat(strings.ReplaceAll(m[2], ",", ""), 64)
+	if err != nil {
+		return p, fmt.Errorf("invalid amount %q", amount)
+	}
+	p.amount = n
+	p.hasAmount = true
+	p.commodity = m[1] + m[3]
+	return p, nil
+}
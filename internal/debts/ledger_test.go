@@ -0,0 +1,133 @@
+package debts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJournalRoundTrip(t *testing.T) {
+	entries := []Entry{
+		{Path: "debts/alice.md", Account: "Assets:Debts:Alice", Amount: 25.5, Currency: "EUR", Comment: "lunch", Created: "2026-10-01T12:30:00"},
+		{Path: "debts/alice.md", Account: "Assets:Debts:Alice", Amount: -10, Currency: "EUR", Comment: "paid back; in cash", Created: "2026-10-02T09:00:00"},
+		{Path: "debts/bob, carol.md", Account: "Assets:Debts:BobCarol", Amount: 3, Currency: "USD", Comment: `coffee, tea \ milk`, Created: "2026-10-03T08:15:00"},
+		{Path: "debts/bob, carol.md", Account: "Assets:Debts:BobCarol", Amount: 100, Currency: "USD", Comment: "rent\nfor october", Created: "2026-10-04T00:00:00"},
+		{Path: `debts/back\slash;.md`, Account: "Assets:Debts:Backslash", Amount: 0.01, Currency: "EUR", Comment: `"quoted"`, Created: "2026-10-05T18:00:00"},
+	}
+	parsers := map[string]func(*strings.Reader) ([]journalTransaction, error){
+		FormatHledger:   func(r *strings.Reader) ([]journalTransaction, error) { return parseHledger(r) },
+		FormatBeancount: func(r *strings.Reader) ([]journalTransaction, error) { return parseBeancount(r) },
+	}
+	for format, parse := range parsers {
+		t.Run(format, func(t *testing.T) {
+			var b strings.Builder
+			writeJournal(&b, entries, format, "Equity:Debts")
+			transactions, err := parse(strings.NewReader(b.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(transactions) != len(entries) {
+				t.Fatalf("got %d transactions, want %d:\n%s", len(transactions), len(entries), b.String())
+			}
+			for i, e := range entries {
+				tr := transactions[i]
+				if tr.date != e.Created[:10] {
+					t.Errorf("%d: date = %q, want %q", i, tr.date, e.Created[:10])
+				}
+				if tr.description != e.Comment {
+					t.Errorf("%d: description = %q, want %q", i, tr.description, e.Comment)
+				}
+				if tr.tags["note"] != e.Path || tr.tags["created"] != e.Created {
+					t.Errorf("%d: tags = %v, want note %q and created %q", i, tr.tags, e.Path, e.Created)
+				}
+				if len(tr.postings) != 2 {
+					t.Fatalf("%d: got %d postings, want 2", i, len(tr.postings))
+				}
+				want := posting{account: e.Account, amount: e.Amount, commodity: e.Currency, hasAmount: true}
+				if tr.postings[0] != want {
+					t.Errorf("%d: posting = %+v, want %+v", i, tr.postings[0], want)
+				}
+				if tr.postings[1].account != "Equity:Debts" {
+					t.Errorf("%d: offset = %q, want Equity:Debts", i, tr.postings[1].account)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHledger(t *testing.T) {
+	journal := `; a comment
+2026/10/01 * (42) Dinner  ; trip:paris
+    Assets:Debts:Alice  $12.50  ; shared
+    Assets:Cash
+
+2026.10.02 Refund
+    ; created: 2026-10-02T10:00:00
+    Assets:Debts:Alice	-1,000.00 EUR
+    Assets:Cash
+`
+	transactions, err := parseHledger(strings.NewReader(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []journalTransaction{
+		{
+			line: 2, date: "2026-10-01", description: "Dinner",
+			tags: map[string]string{"trip": "paris"},
+			postings: []posting{
+				{account: "Assets:Debts:Alice", amount: 12.5, commodity: "$", hasAmount: true},
+				{account: "Assets:Cash"},
+			},
+		},
+		{
+			line: 6, date: "2026-10-02", description: "Refund",
+			tags: map[string]string{"created": "2026-10-02T10:00:00"},
+			postings: []posting{
+				{account: "Assets:Debts:Alice", amount: -1000, commodity: "EUR", hasAmount: true},
+				{account: "Assets:Cash"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(transactions, want) {
+		t.Errorf("parseHledger() = %+v, want %+v", transactions, want)
+	}
+}
+
+func TestParseBeancount(t *testing.T) {
+	journal := `2026-01-01 open Assets:Debts:Alice
+
+2026-10-01 * "Bistro" "Dinner"
+  note: "debts/alice.md"
+  Assets:Debts:Alice  12.50 EUR ; shared
+  Assets:Cash
+
+2026-10-02 txn "Refund \"early\""
+  Assets:Debts:Alice  -5 EUR @ 1.1 USD
+  Assets:Cash
+`
+	transactions, err := parseBeancount(strings.NewReader(journal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []journalTransaction{
+		{
+			line: 3, date: "2026-10-01", description: "Dinner",
+			tags: map[string]string{"note": "debts/alice.md"},
+			postings: []posting{
+				{account: "Assets:Debts:Alice", amount: 12.5, commodity: "EUR", hasAmount: true},
+				{account: "Assets:Cash"},
+			},
+		},
+		{
+			line: 8, date: "2026-10-02", description: `Refund "early"`,
+			tags: map[string]string{},
+			postings: []posting{
+				{account: "Assets:Debts:Alice", amount: -5, commodity: "EUR", hasAmount: true},
+				{account: "Assets:Cash"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(transactions, want) {
+		t.Errorf("parseBeancount() = %+v, want %+v", transactions, want)
+	}
+}
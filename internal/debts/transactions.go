@@ -0,0 +1,100 @@
+package debts
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/revisions"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+// Transactions edits the list of transactions of a note type. The debts and
+// the goals only differ in the note type, the key of the list, the name of
+// an item in the errors and the view, which is returned after a change.
+type Transactions[T any] struct {
+	App        core.App
+	Type       string
+	Key        string
+	Item       string
+	ErrNotType error
+	// Location is the timezone of the dates without one.
+	Location *time.Location
+	View     func(record *core.Record) (T, error)
+}
+
+// Change edits the transactions of a note. The raw frontmatter is edited in
+// place, so that the order of the keys on disk is kept.
+func (t *Transactions[T]) Change(record *core.Record, index int, in *TransactionInput) error {
+	fm, err := frontmatter.Parse(record.GetString("raw_frontmatter"))
+	if err != nil {
+		return err
+	}
+	if fm.GetString("type") != t.Type {
+		return t.ErrNotType
+	}
+	if err := EditTransactions(fm, t.Key, index, in); err != nil {
+		return err
+	}
+	return fm.Save(t.App, record)
+}
+
+// Routes adds the routes, which append, update and remove the transactions
+// of a note, e.g. `/{id}/transactions` and `/{id}/transactions/{index}`.
+func (t *Transactions[T]) Routes(group *router.RouterGroup[*core.RequestEvent]) {
+	path := "/{id}/" + t.Key
+	group.POST(path, func(e *core.RequestEvent) error {
+		return t.handleChange(e, -1, false)
+	})
+	group.PATCH(path+"/{index}", func(e *core.RequestEvent) error {
+		// -1 appends in Change, so it is only reachable with POST
+		index, err := strconv.Atoi(e.Request.PathValue("index"))
+		if err != nil || index < 0 {
+			return apis.NewNotFoundError(t.Item+" not found", nil)
+		}
+		return t.handleChange(e, index, false)
+	})
+	group.DELETE(path+"/{index}", func(e *core.RequestEvent) error {
+		index, err := strconv.Atoi(e.Request.PathValue("index"))
+		if err != nil || index < 0 {
+			return apis.NewNotFoundError(t.Item+" not found", nil)
+		}
+		return t.handleChange(e, index, true)
+	})
+}
+
+func (t *Transactions[T]) handleChange(e *core.RequestEvent, index int, remove bool) error {
+	record, err := t.App.FindRecordById("files", e.Request.PathValue("id"))
+	if err != nil || record.GetString("deleted") != "" {
+		return apis.NewNotFoundError("file not found", nil)
+	}
+	var in *TransactionInput
+	if !remove {
+		in = &TransactionInput{}
+		if err := e.BindBody(in); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		if err := in.Validate(index != -1, t.Location); err != nil {
+			return apis.NewBadRequestError("invalid "+t.Item, err)
+		}
+	}
+	revisions.SetUser(record, e.Auth)
+	if err := t.Change(record, index, in); err != nil {
+		if errors.Is(err, ErrTransactionNotFound) {
+			return apis.NewNotFoundError(t.Item+" not found", nil)
+		}
+		if errors.Is(err, t.ErrNotType) {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return apis.NewBadRequestError("unable to save the "+t.Item, err)
+	}
+	view, err := t.View(record)
+	if err != nil {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	return e.JSON(http.StatusOK, view)
+}
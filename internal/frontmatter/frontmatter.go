@@ -0,0 +1,196 @@
+package frontmatter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Frontmatter is an ordered view of the note metadata. Key order matters,
+// because the frontmatter is written back to disk and shouldn't be reshuffled
+// on every edit.
+type Frontmatter struct {
+	items yaml.MapSlice
+}
+
+// Parse accepts both JSON (as stored in the files table) and YAML (as stored on disk).
+func Parse(raw string) (*Frontmatter, error) {
+	f := &Frontmatter{items: yaml.MapSlice{}}
+	if strings.TrimSpace(raw) == "" || strings.TrimSpace(raw) == "null" {
+		return f, nil
+	}
+	if err := yaml.UnmarshalWithOptions([]byte(raw), &f.items, yaml.UseOrderedMap()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func FromRecord(record *core.Record) (*Frontmatter, error) {
+	return Parse(record.GetString("frontmatter"))
+}
+
+func (f *Frontmatter) Get(key string) (any, bool) {
+	for _, item := range f.items {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return nil, false
+}
+
+// GetString returns the value of a scalar key as a string, empty values and
+// missing keys are both returned as "".
+func (f *Frontmatter) GetString(key string) string {
+	value, _ := f.Get(key)
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		b, _ := json.Marshal(v)
+		return strings.Trim(string(b), `"`)
+	}
+}
+
+// Set replaces the value in place or appends the key to the end.
+func (f *Frontmatter) Set(key string, value any) {
+	for i, item := range f.items {
+		if item.Key == key {
+			f.items[i].Value = value
+			return
+		}
+	}
+	f.items = append(f.items, yaml.MapItem{Key: key, Value: value})
+}
+
+func (f *Frontmatter) Delete(key string) bool {
+	for i, item := range f.items {
+		if item.Key == key {
+			f.items = append(f.items[:i], f.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Rename keeps the position of the key. If the new key already exists,
+// it is overwritten.
+func (f *Frontmatter) Rename(from, to string) bool {
+	if _, ok := f.Get(from); !ok {
+		return false
+	}
+	if from != to {
+		f.Delete(to)
+	}
+	for i := range f.items {
+		if f.items[i].Key == from {
+			f.items[i].Key = to
+		}
+	}
+	return true
+}
+
+func (f *Frontmatter) Keys() []string {
+	keys := make([]string, 0, len(f.items))
+	for _, item := range f.items {
+		keys = append(keys, fmt.Sprint(item.Key))
+	}
+	return keys
+}
+
+func (f *Frontmatter) Len() int {
+	return len(f.items)
+}
+
+// JSON returns the frontmatter in the same format as the sync stores it.
+func (f *Frontmatter) JSON() (string, error) {
+	if len(f.items) == 0 {
+		return "{}", nil
+	}
+	b, err := yaml.MarshalWithOptions(f.items, yaml.JSON())
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// YAML returns the frontmatter as it is written to disk, without the
+// `---` delimiters. Empty frontmatter is an empty string.
+func (f *Frontmatter) YAML() (string, error) {
+	if len(f.items) == 0 {
+		return "", nil
+	}
+	b, err := yaml.Marshal(f.items)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// SetRaw sets the raw frontmatter of a note record together with its JSON
+// and marks the change as made in the db, so that the regular OnRecordUpdate
+// flow writes the note to disk, once the record is saved.
+func SetRaw(record *core.Record, raw string) error {
+	frontmatterJSON, err := utils.YamlToJson(raw)
+	if err != nil {
+		return err
+	}
+	record.Set("raw_frontmatter", raw)
+	record.Set("frontmatter", frontmatterJSON)
+	record.Set("origin", "db")
+	return nil
+}
+
+// Save writes the frontmatter to the note record and saves it. The raw
+// frontmatter is generated from the ordered keys, so that the order of the
+// keys on disk is kept.
+func (f *Frontmatter) Save(app core.App, record *core.Record) error {
+	raw, err := f.YAML()
+	if err != nil {
+		return err
+	}
+	if err := SetRaw(record, raw); err != nil {
+		return err
+	}
+	return app.Save(record)
+}
+
+// Map returns a plain representation of the frontmatter, where numbers are
+// float64 and nested objects are map[string]any, just like encoding/json does.
+func (f *Frontmatter) Map() map[string]any {
+	result := map[string]any{}
+	raw, err := f.JSON()
+	if err != nil {
+		return result
+	}
+	_ = json.Unmarshal([]byte(raw), &result)
+	return result
+}
+
+// Plain converts a single (possibly nested) value to its encoding/json representation.
+func Plain(value any) any {
+	b, err := yaml.MarshalWithOptions(value, yaml.JSON())
+	if err != nil {
+		return value
+	}
+	var result any
+	if err := json.Unmarshal(b, &result); err != nil {
+		return value
+	}
+	return result
+}
+
+// ParseValue parses a command line value as YAML, so that `4` is a number
+// and `[a, b]` is a list, while everything else stays a string.
+func ParseValue(raw string) any {
+	var value any
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+	return value
+}
@@ -0,0 +1,259 @@
+package goals
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/debts"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const goalType = "goal"
+
+// daysPerMonth is the average length of a month, the rates are per month.
+const daysPerMonth = 365.25 / 12
+
+type GoalsHandler struct {
+	app           *pocketbase.PocketBase
+	conf          *config.NotebaseConfig
+	contributions *debts.Transactions[Progress]
+}
+
+// Progress is the state of a single goal note. Contributions are stored the
+// same way as the transactions of the debts.
+type Progress struct {
+	Id        string  `json:"id"`
+	Path      string  `json:"path"`
+	Summary   string  `json:"summary"`
+	Currency  string  `json:"currency"`
+	Target    float64 `json:"target"`
+	Deadline  string  `json:"deadline"`
+	Saved     float64 `json:"saved"`
+	Remaining float64 `json:"remaining"`
+	Percent   float64 `json:"percent"`
+	Completed bool    `json:"completed"`
+	// CompletedAt is the date of the contribution, which reached the target.
+	CompletedAt string `json:"completed_at,omitempty"`
+	// RequiredMonthly is the amount per month, which is left to save to hit
+	// the deadline, it is the whole remainder when the deadline is
+	// less than a month away or overdue.
+	RequiredMonthly float64 `json:"required_monthly"`
+	Overdue         bool    `json:"overdue"`
+	// MonthlyPace is the average contribution per month since the `start` of
+	// the goal or its first contribution.
+	MonthlyPace float64 `json:"monthly_pace"`
+	// Projected is the completion date at the current pace, it is empty when
+	// nothing is saved yet.
+	Projected     string              `json:"projected"`
+	OnTrack       bool                `json:"on_track"`
+	Contributions []debts.Transaction `json:"contributions,omitempty"`
+}
+
+type Total struct {
+	Currency        string  `json:"currency"`
+	Target          float64 `json:"target"`
+	Saved           float64 `json:"saved"`
+	Remaining       float64 `json:"remaining"`
+	RequiredMonthly float64 `json:"required_monthly"`
+	MonthlyPace     float64 `json:"monthly_pace"`
+	Goals           int     `json:"goals"`
+}
+
+type Summary struct {
+	Goals      []Progress `json:"goals"`
+	Currencies []Total    `json:"currencies"`
+}
+
+var ErrNotGoal = errors.New("note is not a goal")
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) *GoalsHandler {
+	return &GoalsHandler{
+		app:  app,
+		conf: conf,
+		contributions: &debts.Transactions[Progress]{
+			App:        app,
+			Type:       goalType,
+			Key:        "contributions",
+			Item:       "contribution",
+			ErrNotType: ErrNotGoal,
+			Location:   conf.Location(),
+			View: func(record *core.Record) (Progress, error) {
+				return progressOf(record, time.Now().In(conf.Location()))
+			},
+		},
+	}
+}
+
+// progressOf computes the progress of a goal note at now, the dates without
+// a timezone are read in the location of now.
+func progressOf(record *core.Record, now time.Time) (Progress, error) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return Progress{}, err
+	}
+	if fm.GetString("type") != goalType {
+		return Progress{}, ErrNotGoal
+	}
+	target, _ := strconv.ParseFloat(fm.GetString("target"), 64)
+	progress := Progress{
+		Id:            record.Id,
+		Path:          record.GetString("path"),
+		Summary:       fm.GetString("summary"),
+		Currency:      fm.GetString("currency"),
+		Target:        target,
+		Deadline:      fm.GetString("deadline"),
+		Contributions: []debts.Transaction{},
+	}
+	type entry struct {
+		at     time.Time
+		amount float64
+	}
+	var entries []entry
+	items, _ := fm.Map()["contributions"].([]any)
+	for i, item := range items {
+		values, _ := item.(map[string]any)
+		amount, _ := values["amount"].(float64)
+		comment, _ := values["comment"].(string)
+		created, _ := values["created"].(string)
+		progress.Contributions = append(progress.Contributions, debts.Transaction{Index: i, Amount: amount, Comment: comment, Created: created})
+		progress.Saved += amount
+		if at, ok := utils.ParseDate(created, now.Location()); ok {
+			entries = append(entries, entry{at, amount})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+
+	progress.Saved = utils.Round(progress.Saved)
+	progress.Remaining = utils.Round(max(target-progress.Saved, 0))
+	if target > 0 {
+		progress.Percent = utils.Round(progress.Saved / target * 100)
+	}
+	progress.Completed = target > 0 && progress.Remaining == 0
+	if progress.Completed {
+		total := 0.0
+		for _, e := range entries {
+			total += e.amount
+			if total >= target {
+				progress.CompletedAt = e.at.Format(time.DateOnly)
+				break
+			}
+		}
+		progress.OnTrack = true
+		return progress, nil
+	}
+
+	deadline, hasDeadline := utils.ParseDate(progress.Deadline, now.Location())
+	if hasDeadline {
+		months := deadline.Sub(now).Hours() / 24 / daysPerMonth
+		progress.Overdue = months <= 0
+		progress.RequiredMonthly = utils.Round(progress.Remaining / max(months, 1))
+	}
+
+	start, ok := utils.ParseDate(fm.GetString("start"), now.Location())
+	if !ok && len(entries) > 0 {
+		start = entries[0].at
+	}
+	if !start.IsZero() && progress.Saved > 0 {
+		elapsed := now.Sub(start).Hours() / 24 / daysPerMonth
+		pace := progress.Saved / max(elapsed, 1)
+		progress.MonthlyPace = utils.Round(pace)
+		projected := now.AddDate(0, 0, int(math.Ceil(progress.Remaining/pace*daysPerMonth)))
+		progress.Projected = projected.Format(time.DateOnly)
+		progress.OnTrack = !hasDeadline || !projected.After(deadline)
+	}
+	return progress, nil
+}
+
+func (h *GoalsHandler) records() ([]*core.Record, error) {
+	return h.app.FindRecordsByFilter("files", "frontmatter.type = 'goal' && deleted = ''", "path", 0, 0)
+}
+
+// Summarize returns the progress of every goal note and the totals per
+// currency.
+func (h *GoalsHandler) Summarize() (Summary, error) {
+	summary := Summary{Goals: []Progress{}, Currencies: []Total{}}
+	records, err := h.records()
+	if err != nil {
+		return summary, err
+	}
+	now := time.Now().In(h.conf.Location())
+	currencies := map[string]*Total{}
+	for _, record := range records {
+		progress, err := progressOf(record, now)
+		if err != nil {
+			continue
+		}
+		progress.Contributions = nil
+		summary.Goals = append(summary.Goals, progress)
+
+		if currencies[progress.Currency] == nil {
+			currencies[progress.Currency] = &Total{Currency: progress.Currency}
+		}
+		total := currencies[progress.Currency]
+		total.Target += progress.Target
+		total.Saved += progress.Saved
+		total.Remaining += progress.Remaining
+		total.RequiredMonthly += progress.RequiredMonthly
+		total.MonthlyPace += progress.MonthlyPace
+		total.Goals++
+	}
+	for _, total := range currencies {
+		total.Target = utils.Round(total.Target)
+		total.Saved = utils.Round(total.Saved)
+		total.Remaining = utils.Round(total.Remaining)
+		total.RequiredMonthly = utils.Round(total.RequiredMonthly)
+		total.MonthlyPace = utils.Round(total.MonthlyPace)
+		summary.Currencies = append(summary.Currencies, *total)
+	}
+	sort.Slice(summary.Currencies, func(i, j int) bool {
+		return summary.Currencies[i].Currency < summary.Currencies[j].Currency
+	})
+	return summary, nil
+}
+
+// Change edits the contributions of a goal note the way the debts edit
+// their transactions.
+func (h *GoalsHandler) Change(record *core.Record, index int, in *debts.TransactionInput) error {
+	return h.contributions.Change(record, index, in)
+}
+
+func (h *GoalsHandler) Routes(se *core.ServeEvent) {
+	goalsGroup := se.Router.Group("/goals")
+	goalsGroup.Bind(apis.RequireSuperuserAuth())
+	goalsGroup.GET("", func(e *core.RequestEvent) error {
+		summary, err := h.Summarize()
+		if err != nil {
+			return apis.NewBadRequestError("unable to summarize goals", err)
+		}
+		return e.JSON(http.StatusOK, summary)
+	})
+	goalsGroup.GET("/{id}", func(e *core.RequestEvent) error {
+		record, err := h.goal(e)
+		if err != nil {
+			return err
+		}
+		progress, err := progressOf(record, time.Now().In(h.conf.Location()))
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, progress)
+	})
+	h.contributions.Routes(goalsGroup)
+}
+
+func (h *GoalsHandler) goal(e *core.RequestEvent) (*core.Record, error) {
+	record, err := h.app.FindRecordById("files", e.Request.PathValue("id"))
+	if err != nil || record.GetString("deleted") != "" {
+		return nil, apis.NewNotFoundError("file not found", nil)
+	}
+	return record, nil
+}
@@ -0,0 +1,470 @@
+package groceries
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/revisions"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	groceriesType = "groceries"
+	recipeType    = "recipe"
+)
+
+// Notes creates, moves and removes the note files.
+type Notes interface {
+	templates.NoteCreator
+	MoveNote(record *core.Record, relPath string) (*core.Record, error)
+	RemoveNote(record *core.Record) error
+}
+
+// GroceriesHandler serializes the changes of the lists, so that concurrent
+// edits of a list do not overwrite each other.
+type GroceriesHandler struct {
+	app   *pocketbase.PocketBase
+	conf  *config.NotebaseConfig
+	notes Notes
+	mu    sync.Mutex
+}
+
+type Recipe struct {
+	Id          string       `json:"id"`
+	Path        string       `json:"path"`
+	Title       string       `json:"title"`
+	Servings    float64      `json:"servings,omitempty"`
+	Ingredients []Ingredient `json:"ingredients"`
+}
+
+// PlanRequest adds the ingredients of the recipes to a groceries list.
+// Recipes are taken from the `recipes` of the Plan note, or from its links
+// when it has none, and from Recipes. The list is the Groceries note, or
+// a note at Path, which is created when it is missing.
+type PlanRequest struct {
+	Plan      string   `json:"plan"`
+	Recipes   []string `json:"recipes"`
+	Groceries string   `json:"groceries"`
+	Path      string   `json:"path"`
+	Title     string   `json:"title"`
+	DryRun    bool     `json:"dry_run"`
+	// Auth is recorded as the author of the revision.
+	Auth *core.Record `json:"-"`
+}
+
+type PlanResult struct {
+	Recipes []string     `json:"recipes"`
+	Added   []Ingredient `json:"added"`
+	// Skipped are the ingredients, which are already on the list.
+	Skipped []Ingredient `json:"skipped"`
+	Path    string       `json:"path"`
+	Created bool         `json:"created"`
+	Record  *core.Record `json:"record,omitempty"`
+}
+
+var (
+	ErrNotRecipe    = errors.New("note is not a recipe")
+	ErrNotGroceries = errors.New("note is not a groceries list")
+	ErrNotFound     = errors.New("note not found")
+)
+
+var linkRe = regexp.MustCompile(`\[\[([^\]|#]+)(?:[#|][^\]]*)?\]\]`)
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig, notes Notes) *GroceriesHandler {
+	return &GroceriesHandler{
+		app:   app,
+		conf:  conf,
+		notes: notes,
+	}
+}
+
+// find resolves a note by its id, its path or a wikilink, links match the
+// file name in any folder the way Obsidian does it.
+func (h *GroceriesHandler) find(ref string) (*core.Record, error) {
+	ref = strings.TrimSpace(ref)
+	if m := linkRe.FindStringSubmatch(ref); m != nil {
+		ref = strings.TrimSpace(m[1])
+	}
+	if ref == "" {
+		return nil, ErrNotFound
+	}
+	if record, err := h.app.FindRecordById("files", ref); err == nil && record.GetString("deleted") == "" {
+		return record, nil
+	}
+	path := ref
+	if filepath.Ext(path) != ".md" {
+		path += ".md"
+	}
+	records, err := h.app.FindRecordsByFilter(
+		"files",
+		"deleted = '' && (path = {:path} || path ~ {:suffix})",
+		"path", 1, 0,
+		dbx.Params{"path": path, "suffix": "%/" + utils.EscapeLike(path)},
+	)
+	if err != nil || len(records) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	return records[0], nil
+}
+
+func recipeOf(record *core.Record) (Recipe, error) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return Recipe{}, err
+	}
+	if fm.GetString("type") != recipeType {
+		return Recipe{}, ErrNotRecipe
+	}
+	recipe := Recipe{
+		Id:          record.Id,
+		Path:        record.GetString("path"),
+		Title:       fm.GetString("title"),
+		Ingredients: []Ingredient{},
+	}
+	if recipe.Title == "" {
+		recipe.Title = templates.Title(recipe.Path)
+	}
+	recipe.Servings, _ = strconv.ParseFloat(fm.GetString("servings"), 64)
+	items, _ := fm.Map()["ingredients"].([]any)
+	for _, item := range items {
+		ingredient, err := ParseIngredient(item)
+		if err != nil {
+			return recipe, fmt.Errorf("%s: %w", recipe.Path, err)
+		}
+		recipe.Ingredients = append(recipe.Ingredients, ingredient)
+	}
+	return recipe, nil
+}
+
+// planned is a recipe of a meal plan, Servings scale the recipe.
+type planned struct {
+	ref      string
+	servings float64
+}
+
+func (h *GroceriesHandler) planEntries(ref string) ([]planned, error) {
+	record, err := h.find(ref)
+	if err != nil {
+		return nil, err
+	}
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	entries := []planned{}
+	items, _ := fm.Map()["recipes"].([]any)
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			entries = append(entries, planned{ref: v})
+		case map[string]any:
+			servings, _ := v["servings"].(float64)
+			entries = append(entries, planned{ref: stringValue(v["recipe"]), servings: servings})
+		default:
+			return nil, fmt.Errorf("invalid recipe %v", item)
+		}
+	}
+	if len(entries) > 0 {
+		return entries, nil
+	}
+	// plans without the recipes key link the recipes in the content,
+	// links to other notes are skipped
+	for _, m := range linkRe.FindAllStringSubmatch(record.GetString("content"), -1) {
+		linked, err := h.find(m[0])
+		if err != nil {
+			continue
+		}
+		if _, err := recipeOf(linked); err == nil {
+			entries = append(entries, planned{ref: linked.Id})
+		}
+	}
+	return entries, nil
+}
+
+// Ingredients returns the merged ingredients of the planned recipes.
+func (h *GroceriesHandler) Ingredients(req PlanRequest) ([]Ingredient, []string, error) {
+	entries := []planned{}
+	if req.Plan != "" {
+		var err error
+		entries, err = h.planEntries(req.Plan)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, ref := range req.Recipes {
+		entries = append(entries, planned{ref: ref})
+	}
+	if len(entries) == 0 {
+		return nil, nil, errors.New("no recipes to plan")
+	}
+	all := []Ingredient{}
+	paths := []string{}
+	for _, entry := range entries {
+		record, err := h.find(entry.ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		recipe, err := recipeOf(record)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", record.GetString("path"), err)
+		}
+		ingredients := recipe.Ingredients
+		if entry.servings > 0 && recipe.Servings > 0 {
+			ingredients = Scale(ingredients, entry.servings/recipe.Servings)
+		}
+		all = append(all, ingredients...)
+		paths = append(paths, recipe.Path)
+	}
+	return Merge(all), paths, nil
+}
+
+// Plan appends the ingredients of the recipes, which are not on the list yet,
+// to the groceries list.
+func (h *GroceriesHandler) Plan(req PlanRequest) (PlanResult, error) {
+	result := PlanResult{Added: []Ingredient{}, Skipped: []Ingredient{}}
+	ingredients, paths, err := h.Ingredients(req)
+	if err != nil {
+		return result, err
+	}
+	result.Recipes = paths
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var record *core.Record
+	if req.Groceries != "" {
+		record, err = h.find(req.Groceries)
+		if err != nil {
+			return result, err
+		}
+	} else {
+		if req.Title == "" {
+			req.Title = "Groceries " + time.Now().Format(time.DateOnly)
+			if req.Plan != "" {
+				if plan, err := h.find(req.Plan); err == nil {
+					req.Title = templates.Title(plan.GetString("path")) + " groceries"
+				}
+			}
+		}
+		if req.Path == "" {
+			req.Path = filepath.Join(h.conf.Groceries.Folder, req.Title)
+		}
+		if filepath.Ext(req.Path) != ".md" {
+			req.Path += ".md"
+		}
+		req.Path = filepath.Clean(req.Path)
+		record, _ = h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": req.Path})
+	}
+
+	var fm *frontmatter.Frontmatter
+	if record != nil {
+		result.Path = record.GetString("path")
+		fm, err = frontmatter.Parse(record.GetString("raw_frontmatter"))
+		if err != nil {
+			return result, err
+		}
+		if fm.GetString("type") != groceriesType {
+			return result, ErrNotGroceries
+		}
+	} else {
+		result.Path = req.Path
+		result.Created = true
+		fm, _ = frontmatter.Parse("")
+		fm.Set("type", groceriesType)
+		fm.Set("title", req.Title)
+		fm.Set("created", time.Now().Format("2006-01-02T15:04:05"))
+	}
+
+	checklist := checklistOf(fm)
+	existing := map[string]bool{}
+	for _, item := range checklist {
+		existing[strings.ToLower(itemName(item))] = true
+	}
+	added := map[string]bool{}
+	for _, ingredient := range ingredients {
+		if existing[strings.ToLower(ingredient.Name)] {
+			result.Skipped = append(result.Skipped, ingredient)
+			continue
+		}
+		// the items are identified by name, so quantities, which can not
+		// be summed, get the unit in the name
+		if added[strings.ToLower(ingredient.Name)] {
+			ingredient.Name = fmt.Sprintf("%s (%s)", ingredient.Name, cmp.Or(ingredient.Unit, "pcs"))
+		}
+		added[strings.ToLower(ingredient.Name)] = true
+		result.Added = append(result.Added, ingredient)
+		item := yaml.MapSlice{{Key: "name", Value: ingredient.Name}, {Key: "done", Value: false}}
+		if ingredient.Quantity != 0 {
+			item = append(item, yaml.MapItem{Key: "quantity", Value: utils.Number(ingredient.Quantity)})
+		}
+		if ingredient.Unit != "" {
+			item = append(item, yaml.MapItem{Key: "unit", Value: ingredient.Unit})
+		}
+		checklist = append(checklist, item)
+	}
+	if req.DryRun || (record != nil && len(result.Added) == 0) {
+		return result, nil
+	}
+	fm.Set("checklist", checklist)
+
+	if record == nil {
+		rawFrontmatter, err := fm.YAML()
+		if err != nil {
+			return result, err
+		}
+		result.Record, err = h.notes.CreateNote(req.Path, rawFrontmatter, "\n# "+req.Title+"\n")
+		return result, err
+	}
+	result.Record = record
+	revisions.SetUser(record, req.Auth)
+	return result, fm.Save(h.app, record)
+}
+
+// checklistOf returns the `checklist` items of the raw frontmatter.
+func checklistOf(fm *frontmatter.Frontmatter) []any {
+	value, _ := fm.Get("checklist")
+	checklist, _ := value.([]any)
+	return checklist
+}
+
+func itemName(item any) string {
+	values, _ := item.(yaml.MapSlice)
+	for _, value := range values {
+		if value.Key == "name" {
+			return stringValue(value.Value)
+		}
+	}
+	return ""
+}
+
+func (h *GroceriesHandler) Routes(se *core.ServeEvent) {
+	groceriesGroup := se.Router.Group("/groceries")
+	groceriesGroup.Bind(apis.RequireSuperuserAuth())
+	groceriesGroup.GET("/recipes/{id}", func(e *core.RequestEvent) error {
+		record, err := h.find(e.Request.PathValue("id"))
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		recipe, err := recipeOf(record)
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, recipe)
+	})
+	groceriesGroup.POST("/plan", func(e *core.RequestEvent) error {
+		req := PlanRequest{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		req.Auth = e.Auth
+		result, err := h.Plan(req)
+		if errors.Is(err, ErrNotFound) {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		if result.Created && !req.DryRun {
+			return e.JSON(http.StatusCreated, result)
+		}
+		return e.JSON(http.StatusOK, result)
+	})
+	groceriesGroup.POST("/archive", func(e *core.RequestEvent) error {
+		paths, err := h.ArchiveCompleted(e.Auth)
+		if err != nil {
+			return groceriesError(err)
+		}
+		return e.JSON(http.StatusOK, map[string][]string{"archived": paths})
+	})
+
+	// items are addressed by their index or by their name
+	groceriesGroup.POST("/{id}/items", func(e *core.RequestEvent) error {
+		in := ItemInput{}
+		if err := e.BindBody(&in); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		return respond(e, http.StatusCreated)(h.Add(e.Request.PathValue("id"), in, e.Auth))
+	})
+	groceriesGroup.POST("/{id}/items/{item}/check", func(e *core.RequestEvent) error {
+		return respond(e, http.StatusOK)(h.Check(e.Request.PathValue("id"), e.Request.PathValue("item"), true, e.Auth))
+	})
+	groceriesGroup.POST("/{id}/items/{item}/uncheck", func(e *core.RequestEvent) error {
+		return respond(e, http.StatusOK)(h.Check(e.Request.PathValue("id"), e.Request.PathValue("item"), false, e.Auth))
+	})
+	groceriesGroup.POST("/{id}/items/{item}/move", func(e *core.RequestEvent) error {
+		req := struct {
+			Position int `json:"position"`
+		}{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		return respond(e, http.StatusOK)(h.Move(e.Request.PathValue("id"), e.Request.PathValue("item"), req.Position, e.Auth))
+	})
+	groceriesGroup.DELETE("/{id}/items/{item}", func(e *core.RequestEvent) error {
+		return respond(e, http.StatusOK)(h.Remove(e.Request.PathValue("id"), e.Request.PathValue("item"), e.Auth))
+	})
+
+	groceriesGroup.POST("/{id}/carry-over", func(e *core.RequestEvent) error {
+		req := ListRequest{}
+		if e.Request.ContentLength > 0 {
+			if err := e.BindBody(&req); err != nil {
+				return apis.NewBadRequestError("invalid request", err)
+			}
+		}
+		req.Auth = e.Auth
+		return respond(e, http.StatusOK)(h.CarryOver(e.Request.PathValue("id"), req))
+	})
+	groceriesGroup.POST("/{id}/merge", func(e *core.RequestEvent) error {
+		req := struct {
+			From   string `json:"from"`
+			Remove bool   `json:"remove"`
+		}{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		return respond(e, http.StatusOK)(h.Merge(e.Request.PathValue("id"), req.From, req.Remove, e.Auth))
+	})
+	groceriesGroup.POST("/{id}/archive", func(e *core.RequestEvent) error {
+		req := struct {
+			Force bool `json:"force"`
+		}{}
+		if e.Request.ContentLength > 0 {
+			if err := e.BindBody(&req); err != nil {
+				return apis.NewBadRequestError("invalid request", err)
+			}
+		}
+		return respond(e, http.StatusOK)(h.Archive(e.Request.PathValue("id"), req.Force, e.Auth))
+	})
+}
+
+// respond writes the changed list or the error of a list operation.
+func respond(e *core.RequestEvent, status int) func(*core.Record, error) error {
+	return func(record *core.Record, err error) error {
+		if err != nil {
+			return groceriesError(err)
+		}
+		return e.JSON(status, record)
+	}
+}
+
+func groceriesError(err error) error {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrItemNotFound) {
+		return apis.NewNotFoundError(err.Error(), nil)
+	}
+	return apis.NewBadRequestError(err.Error(), nil)
+}
@@ -0,0 +1,451 @@
+package groceries
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/moment"
+	"github.com/biozz/wow/notebase/internal/revisions"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ItemInput is a new item of a list, Position inserts it instead of
+// appending it to the end.
+type ItemInput struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+	Position *int    `json:"position"`
+}
+
+// ListRequest is the target of the carry over, which is an existing list
+// or a new list at Path.
+type ListRequest struct {
+	Path  string `json:"path"`
+	Title string `json:"title"`
+	// Auth is recorded as the author of the revisions.
+	Auth *core.Record `json:"-"`
+}
+
+var (
+	ErrItemNotFound = errors.New("item not found")
+	ErrItemExists   = errors.New("item is already on the list")
+	ErrNotCompleted = errors.New("list has unchecked items")
+	ErrNothingToDo  = errors.New("list has no unchecked items")
+)
+
+// load reads a groceries list with its raw frontmatter.
+func (h *GroceriesHandler) load(ref string) (*core.Record, *frontmatter.Frontmatter, error) {
+	record, err := h.find(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	fm, err := frontmatter.Parse(record.GetString("raw_frontmatter"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if fm.GetString("type") != groceriesType {
+		return nil, nil, ErrNotGroceries
+	}
+	return record, fm, nil
+}
+
+// Update applies the change to the checklist of the list. The list is read
+// again under the lock, so the change is applied to its latest state.
+//
+// The lock only covers the groceries endpoints and the updates of the lists
+// through the records API, see GuardRequest. A full frontmatter update
+// through the records API is only safe with If-Match set to the `updated`
+// of the list, which the client has read, otherwise it overwrites the
+// changes made since then.
+func (h *GroceriesHandler) Update(ref string, auth *core.Record, change func(checklist []any) ([]any, error)) (*core.Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	record, fm, err := h.load(ref)
+	if err != nil {
+		return nil, err
+	}
+	checklist, err := change(checklistOf(fm))
+	if err != nil {
+		return nil, err
+	}
+	fm.Set("checklist", checklist)
+	revisions.SetUser(record, auth)
+	return record, fm.Save(h.app, record)
+}
+
+// GuardRequest serializes the updates of the lists through the records API
+// with Update and rejects them with 412, when If-Match does not match the
+// `updated` of the stored list.
+func (h *GroceriesHandler) GuardRequest(e *core.RecordRequestEvent) error {
+	original := e.Record.Original()
+	fm, err := frontmatter.Parse(original.GetString("raw_frontmatter"))
+	if err != nil || fm.GetString("type") != groceriesType {
+		return e.Next()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if match := e.Request.Header.Get("If-Match"); match != "" && match != "*" {
+		current, err := h.app.FindRecordById("files", original.Id)
+		if err != nil {
+			return apis.NewNotFoundError("file not found", nil)
+		}
+		if strings.Trim(match, `"`) != current.GetString("updated") {
+			return apis.NewApiError(http.StatusPreconditionFailed, "the list has changed since it was read", nil)
+		}
+	}
+	return e.Next()
+}
+
+// locate finds an item by its index or by its name, names are compared
+// case-insensitively.
+func locate(checklist []any, ref string) (int, error) {
+	if index, err := strconv.Atoi(ref); err == nil {
+		if index < 0 || index >= len(checklist) {
+			return 0, ErrItemNotFound
+		}
+		return index, nil
+	}
+	for i, item := range checklist {
+		if strings.EqualFold(itemName(item), strings.TrimSpace(ref)) {
+			return i, nil
+		}
+	}
+	return 0, ErrItemNotFound
+}
+
+func field(item any, key string) any {
+	values, _ := item.(yaml.MapSlice)
+	for _, value := range values {
+		if value.Key == key {
+			return value.Value
+		}
+	}
+	return nil
+}
+
+func setField(item any, key string, value any) yaml.MapSlice {
+	values, _ := item.(yaml.MapSlice)
+	for i := range values {
+		if values[i].Key == key {
+			values[i].Value = value
+			return values
+		}
+	}
+	return append(values, yaml.MapItem{Key: key, Value: value})
+}
+
+func isDone(item any) bool {
+	done, _ := field(item, "done").(bool)
+	return done
+}
+
+func (h *GroceriesHandler) Check(ref, item string, done bool, auth *core.Record) (*core.Record, error) {
+	return h.Update(ref, auth, func(checklist []any) ([]any, error) {
+		i, err := locate(checklist, item)
+		if err != nil {
+			return nil, err
+		}
+		checklist[i] = setField(checklist[i], "done", done)
+		return checklist, nil
+	})
+}
+
+// Add appends an item, an item, which is already bought, is unchecked
+// instead.
+func (h *GroceriesHandler) Add(ref string, in ItemInput, auth *core.Record) (*core.Record, error) {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	return h.Update(ref, auth, func(checklist []any) ([]any, error) {
+		if i, err := locate(checklist, in.Name); err == nil {
+			if !isDone(checklist[i]) {
+				return nil, ErrItemExists
+			}
+			checklist[i] = setField(checklist[i], "done", false)
+			return checklist, nil
+		}
+		item := yaml.MapSlice{{Key: "name", Value: in.Name}, {Key: "done", Value: false}}
+		if in.Quantity != 0 {
+			item = append(item, yaml.MapItem{Key: "quantity", Value: utils.Number(in.Quantity)})
+		}
+		if in.Unit != "" {
+			item = append(item, yaml.MapItem{Key: "unit", Value: in.Unit})
+		}
+		if in.Position == nil {
+			return append(checklist, item), nil
+		}
+		position := min(max(*in.Position, 0), len(checklist))
+		return append(checklist[:position], append([]any{item}, checklist[position:]...)...), nil
+	})
+}
+
+func (h *GroceriesHandler) Remove(ref, item string, auth *core.Record) (*core.Record, error) {
+	return h.Update(ref, auth, func(checklist []any) ([]any, error) {
+		i, err := locate(checklist, item)
+		if err != nil {
+			return nil, err
+		}
+		return append(checklist[:i], checklist[i+1:]...), nil
+	})
+}
+
+// Move moves an item to the position, positions past the end move it to
+// the end.
+func (h *GroceriesHandler) Move(ref, item string, position int, auth *core.Record) (*core.Record, error) {
+	if position < 0 {
+		return nil, errors.New("position must not be negative")
+	}
+	return h.Update(ref, auth, func(checklist []any) ([]any, error) {
+		i, err := locate(checklist, item)
+		if err != nil {
+			return nil, err
+		}
+		moved := checklist[i]
+		checklist = append(checklist[:i], checklist[i+1:]...)
+		position = min(position, len(checklist))
+		return append(checklist[:position], append([]any{moved}, checklist[position:]...)...), nil
+	})
+}
+
+// mergeItems adds the items to the checklist. Items with the same name are
+// unchecked, when they are still needed, and their quantities are summed in
+// the unit of the checklist, when the units are compatible. Otherwise the
+// item gets its unit in the name, the same way as in Plan, so that its
+// quantity is not lost.
+func mergeItems(checklist, items []any) []any {
+	for _, item := range items {
+		i, err := locate(checklist, itemName(item))
+		if err == nil && incompatible(checklist[i], item) {
+			unit, _ := field(item, "unit").(string)
+			values, _ := item.(yaml.MapSlice)
+			item = setField(slices.Clone(values), "name", fmt.Sprintf("%s (%s)", itemName(item), cmp.Or(unit, "pcs")))
+			i, err = locate(checklist, itemName(item))
+		}
+		if err != nil {
+			checklist = append(checklist, item)
+			continue
+		}
+		if !isDone(item) && isDone(checklist[i]) {
+			checklist[i] = setField(checklist[i], "done", false)
+		}
+		a, okA := quantityOf(checklist[i])
+		b, okB := quantityOf(item)
+		unitA, _ := field(checklist[i], "unit").(string)
+		unitB, _ := field(item, "unit").(string)
+		if ua, ub := unitOf(unitA), unitOf(unitB); okA && okB && ua.family == ub.family {
+			sum := a + b*ub.factor/ua.factor
+			checklist[i] = setField(checklist[i], "quantity", utils.Number(utils.Round(sum)))
+		}
+	}
+	return checklist
+}
+
+// incompatible reports whether both items have quantities, which can not be
+// summed.
+func incompatible(a, b any) bool {
+	_, okA := quantityOf(a)
+	_, okB := quantityOf(b)
+	unitA, _ := field(a, "unit").(string)
+	unitB, _ := field(b, "unit").(string)
+	return okA && okB && unitOf(unitA).family != unitOf(unitB).family
+}
+
+// quantityOf reads a quantity of the raw frontmatter, where the integers
+// are not floats.
+func quantityOf(item any) (float64, bool) {
+	switch v := field(item, "quantity").(type) {
+	case float64:
+		return v, true
+	case uint64:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// CarryOver moves the unchecked items of the list to another list.
+func (h *GroceriesHandler) CarryOver(ref string, req ListRequest) (*core.Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	source, fm, err := h.load(ref)
+	if err != nil {
+		return nil, err
+	}
+	var kept, carried []any
+	for _, item := range checklistOf(fm) {
+		if isDone(item) {
+			kept = append(kept, item)
+		} else {
+			carried = append(carried, item)
+		}
+	}
+	if len(carried) == 0 {
+		return nil, ErrNothingToDo
+	}
+	if req.Title == "" {
+		req.Title = "Groceries " + time.Now().Format(time.DateOnly)
+	}
+	if req.Path == "" {
+		req.Path = filepath.Join(h.conf.Groceries.Folder, req.Title)
+	}
+	if filepath.Ext(req.Path) != ".md" {
+		req.Path += ".md"
+	}
+	req.Path = filepath.Clean(req.Path)
+	if req.Path == source.GetString("path") {
+		return nil, errors.New("target is the same list")
+	}
+
+	var target *core.Record
+	if existing, err := h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": req.Path}); err == nil {
+		var targetFm *frontmatter.Frontmatter
+		target, targetFm, err = h.load(existing.Id)
+		if err != nil {
+			return nil, err
+		}
+		targetFm.Set("checklist", mergeItems(checklistOf(targetFm), carried))
+		revisions.SetUser(target, req.Auth)
+		if err := targetFm.Save(h.app, target); err != nil {
+			return nil, err
+		}
+	} else {
+		targetFm, _ := frontmatter.Parse("")
+		targetFm.Set("type", groceriesType)
+		targetFm.Set("title", req.Title)
+		targetFm.Set("created", time.Now().Format("2006-01-02T15:04:05"))
+		targetFm.Set("checklist", carried)
+		rawFrontmatter, err := targetFm.YAML()
+		if err != nil {
+			return nil, err
+		}
+		target, err = h.notes.CreateNote(req.Path, rawFrontmatter, "\n# "+req.Title+"\n")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if kept == nil {
+		kept = []any{}
+	}
+	fm.Set("checklist", kept)
+	revisions.SetUser(source, req.Auth)
+	if err := fm.Save(h.app, source); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// Merge adds the items of another list to the list, remove deletes the other
+// list afterwards.
+func (h *GroceriesHandler) Merge(ref, from string, remove bool, auth *core.Record) (*core.Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	target, fm, err := h.load(ref)
+	if err != nil {
+		return nil, err
+	}
+	source, sourceFm, err := h.load(from)
+	if err != nil {
+		return nil, err
+	}
+	if source.Id == target.Id {
+		return nil, errors.New("can not merge a list into itself")
+	}
+	fm.Set("checklist", mergeItems(checklistOf(fm), checklistOf(sourceFm)))
+	revisions.SetUser(target, auth)
+	if err := fm.Save(h.app, target); err != nil {
+		return nil, err
+	}
+	if remove {
+		if err := h.notes.RemoveNote(source); err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+// Archive marks the list as completed and moves it to the dated archive
+// folder. Lists with unchecked items are only archived with force.
+func (h *GroceriesHandler) Archive(ref string, force bool, auth *core.Record) (*core.Record, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.archive(ref, force, auth)
+}
+
+func (h *GroceriesHandler) archive(ref string, force bool, auth *core.Record) (*core.Record, error) {
+	record, fm, err := h.load(ref)
+	if err != nil {
+		return nil, err
+	}
+	if h.archived(record.GetString("path")) {
+		return nil, errors.New("list is already archived")
+	}
+	if !force && !completed(checklistOf(fm)) {
+		return nil, ErrNotCompleted
+	}
+	now := time.Now()
+	if fm.GetString("completed") == "" {
+		fm.Set("completed", now.Format("2006-01-02T15:04:05"))
+		revisions.SetUser(record, auth)
+		if err := fm.Save(h.app, record); err != nil {
+			return nil, err
+		}
+	}
+	path := filepath.Join(h.conf.Groceries.ArchiveFolder, moment.Format(now, h.conf.Groceries.ArchiveFormat), filepath.Base(record.GetString("path")))
+	return h.notes.MoveNote(record, path)
+}
+
+// ArchiveCompleted archives every list, which has all of its items checked.
+func (h *GroceriesHandler) ArchiveCompleted(auth *core.Record) ([]string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records, err := h.app.FindRecordsByFilter("files", "frontmatter.type = 'groceries' && deleted = ''", "path", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, record := range records {
+		if h.archived(record.GetString("path")) {
+			continue
+		}
+		fm, err := frontmatter.Parse(record.GetString("raw_frontmatter"))
+		if err != nil || !completed(checklistOf(fm)) {
+			continue
+		}
+		archived, err := h.archive(record.Id, false, auth)
+		if err != nil {
+			return paths, fmt.Errorf("%s: %w", record.GetString("path"), err)
+		}
+		paths = append(paths, archived.GetString("path"))
+	}
+	return paths, nil
+}
+
+func (h *GroceriesHandler) archived(path string) bool {
+	return utils.Within(h.conf.Groceries.ArchiveFolder, path)
+}
+
+// completed reports whether every item of a non-empty list is checked.
+func completed(checklist []any) bool {
+	for _, item := range checklist {
+		if !isDone(item) {
+			return false
+		}
+	}
+	return len(checklist) > 0
+}
@@ -0,0 +1,78 @@
+package groceries
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+)
+
+func listItem(name string, done bool, quantity any, unit string) yaml.MapSlice {
+	values := yaml.MapSlice{{Key: "name", Value: name}, {Key: "done", Value: done}}
+	if quantity != nil {
+		values = append(values, yaml.MapItem{Key: "quantity", Value: quantity})
+	}
+	if unit != "" {
+		values = append(values, yaml.MapItem{Key: "unit", Value: unit})
+	}
+	return values
+}
+
+func TestMergeItems(t *testing.T) {
+	tests := []struct {
+		name      string
+		checklist []any
+		items     []any
+		want      []any
+	}{
+		{
+			name:      "new item is appended",
+			checklist: []any{listItem("milk", false, uint64(1), "l")},
+			items:     []any{listItem("eggs", false, uint64(6), "")},
+			want:      []any{listItem("milk", false, uint64(1), "l"), listItem("eggs", false, uint64(6), "")},
+		},
+		{
+			name:      "compatible units are summed in the unit of the list",
+			checklist: []any{listItem("flour", false, uint64(1), "kg")},
+			items:     []any{listItem("flour", false, uint64(500), "g")},
+			want:      []any{listItem("flour", false, 1.5, "kg")},
+		},
+		{
+			name:      "bought item is unchecked",
+			checklist: []any{listItem("Milk", true, uint64(1), "l")},
+			items:     []any{listItem("milk", false, uint64(1), "l")},
+			want:      []any{listItem("Milk", false, int64(2), "l")},
+		},
+		{
+			name:      "incompatible units keep the unit in the name",
+			checklist: []any{listItem("rice", false, uint64(1), "cup")},
+			items:     []any{listItem("rice", false, uint64(200), "g")},
+			want:      []any{listItem("rice", false, uint64(1), "cup"), listItem("rice (g)", false, uint64(200), "g")},
+		},
+		{
+			name:      "incompatible units are summed with the item of their unit",
+			checklist: []any{listItem("rice", false, uint64(1), "cup"), listItem("rice (g)", false, uint64(100), "g")},
+			items:     []any{listItem("rice", false, uint64(200), "g")},
+			want:      []any{listItem("rice", false, uint64(1), "cup"), listItem("rice (g)", false, int64(300), "g")},
+		},
+		{
+			name:      "counts without a unit",
+			checklist: []any{listItem("lemons", false, uint64(500), "g")},
+			items:     []any{listItem("lemons", false, uint64(2), "")},
+			want:      []any{listItem("lemons", false, uint64(500), "g"), listItem("lemons (pcs)", false, uint64(2), "")},
+		},
+		{
+			name:      "items without a quantity are not renamed",
+			checklist: []any{listItem("salt", false, nil, "")},
+			items:     []any{listItem("salt", false, uint64(1), "kg")},
+			want:      []any{listItem("salt", false, nil, "")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeItems(tt.checklist, tt.items); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeItems() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
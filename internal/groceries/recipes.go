@@ -0,0 +1,175 @@
+package groceries
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/biozz/wow/notebase/internal/utils"
+)
+
+// Ingredient is a single line of the `ingredients` of a recipe note, written
+// either as `{quantity, unit, name}` or as text, e.g. `500 g flour`.
+type Ingredient struct {
+	Quantity float64 `json:"quantity,omitempty"`
+	Unit     string  `json:"unit,omitempty"`
+	Name     string  `json:"name"`
+}
+
+type unit struct {
+	family string
+	// factor converts the unit to the base unit of the family
+	factor float64
+}
+
+// units are the units, which can be summed with each other. Unknown units,
+// e.g. `clove`, are only summed with themselves.
+var units = map[string]unit{
+	"mg": {"mass", 0.001}, "g": {"mass", 1}, "kg": {"mass", 1000},
+	"gram": {"mass", 1}, "grams": {"mass", 1}, "kilogram": {"mass", 1000}, "kilograms": {"mass", 1000},
+	"oz": {"mass", 28.3495}, "lb": {"mass", 453.592}, "lbs": {"mass", 453.592},
+	"ml": {"volume", 1}, "l": {"volume", 1000},
+	"liter": {"volume", 1000}, "liters": {"volume", 1000}, "litre": {"volume", 1000}, "litres": {"volume", 1000},
+	"tsp": {"volume", 4.92892}, "teaspoon": {"volume", 4.92892}, "teaspoons": {"volume", 4.92892},
+	"tbsp": {"volume", 14.7868}, "tablespoon": {"volume", 14.7868}, "tablespoons": {"volume", 14.7868},
+	"cup": {"volume", 236.588}, "cups": {"volume", 236.588},
+	"": {"count", 1}, "pc": {"count", 1}, "pcs": {"count", 1}, "piece": {"count", 1}, "pieces": {"count", 1},
+}
+
+var ingredientRe = regexp.MustCompile(`^(\d+(?:[.,]\d+)?|\d+/\d+)\s*([^\d\s]*)\s+(.+)$`)
+
+// ParseIngredient parses a frontmatter value of the `ingredients` list.
+func ParseIngredient(value any) (Ingredient, error) {
+	switch v := value.(type) {
+	case string:
+		return parseText(v)
+	case map[string]any:
+		ingredient := Ingredient{
+			Name: strings.TrimSpace(fmt.Sprint(v["name"])),
+			Unit: strings.TrimSpace(stringValue(v["unit"])),
+		}
+		switch q := v["quantity"].(type) {
+		case float64:
+			ingredient.Quantity = q
+		case string:
+			n, ok := parseNumber(q)
+			if !ok {
+				return ingredient, fmt.Errorf("invalid quantity %q", q)
+			}
+			ingredient.Quantity = n
+		}
+		if v["name"] == nil || ingredient.Name == "" {
+			return ingredient, fmt.Errorf("ingredient name is required")
+		}
+		return ingredient, nil
+	}
+	return Ingredient{}, fmt.Errorf("invalid ingredient %v", value)
+}
+
+// parseText splits `500 g flour` or `2 eggs`, the unit is only taken from
+// the text when it is known, so that `2 eggs` is not two `eggs` of nothing.
+func parseText(text string) (Ingredient, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return Ingredient{}, fmt.Errorf("ingredient name is required")
+	}
+	m := ingredientRe.FindStringSubmatch(text)
+	if m == nil {
+		return Ingredient{Name: text}, nil
+	}
+	quantity, _ := parseNumber(m[1])
+	if _, ok := units[strings.ToLower(m[2])]; ok && m[2] != "" {
+		return Ingredient{Quantity: quantity, Unit: m[2], Name: m[3]}, nil
+	}
+	return Ingredient{Quantity: quantity, Name: strings.TrimSpace(m[2] + " " + m[3])}, nil
+}
+
+func parseNumber(value string) (float64, bool) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", ".")
+	if num, den, ok := strings.Cut(value, "/"); ok {
+		a, errA := strconv.ParseFloat(num, 64)
+		b, errB := strconv.ParseFloat(den, 64)
+		if errA != nil || errB != nil || b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	return n, err == nil
+}
+
+func stringValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func unitOf(name string) unit {
+	if u, ok := units[strings.ToLower(name)]; ok {
+		return u
+	}
+	return unit{family: strings.ToLower(name), factor: 1}
+}
+
+// Merge sums the quantities of the ingredients with the same name and
+// compatible units, keeping the order of the first occurrences. The unit is
+// kept when all the quantities use it, otherwise the sum is in grams or
+// milliliters, or kilograms and liters, when it is large enough.
+func Merge(ingredients []Ingredient) []Ingredient {
+	type group struct {
+		unit  string
+		mixed bool
+		base  float64
+		item  Ingredient
+	}
+	var order []string
+	groups := map[string]*group{}
+	for _, ingredient := range ingredients {
+		u := unitOf(ingredient.Unit)
+		key := strings.ToLower(ingredient.Name) + "\x00" + u.family
+		g, ok := groups[key]
+		if !ok {
+			g = &group{unit: ingredient.Unit, item: ingredient}
+			groups[key] = g
+			order = append(order, key)
+		}
+		if !strings.EqualFold(g.unit, ingredient.Unit) {
+			g.mixed = true
+		}
+		g.base += ingredient.Quantity * u.factor
+	}
+	merged := []Ingredient{}
+	for _, key := range order {
+		g := groups[key]
+		item := g.item
+		switch {
+		case !g.mixed:
+			item.Quantity = g.base / unitOf(g.unit).factor
+		case unitOf(g.unit).family == "mass" && g.base >= 1000:
+			item.Quantity, item.Unit = g.base/1000, "kg"
+		case unitOf(g.unit).family == "mass":
+			item.Quantity, item.Unit = g.base, "g"
+		case unitOf(g.unit).family == "volume" && g.base >= 1000:
+			item.Quantity, item.Unit = g.base/1000, "l"
+		case unitOf(g.unit).family == "volume":
+			item.Quantity, item.Unit = g.base, "ml"
+		default:
+			item.Quantity = g.base
+		}
+		item.Quantity = utils.Round(item.Quantity)
+		merged = append(merged, item)
+	}
+	return merged
+}
+
+// Scale multiplies the quantities, e.g. to cook a recipe for more servings.
+func Scale(ingredients []Ingredient, factor float64) []Ingredient {
+	scaled := make([]Ingredient, len(ingredients))
+	for i, ingredient := range ingredients {
+		ingredient.Quantity *= factor
+		scaled[i] = ingredient
+	}
+	return scaled
+}
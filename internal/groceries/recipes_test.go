@@ -0,0 +1,87 @@
+package groceries
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		text string
+		want Ingredient
+	}{
+		{"500 g flour", Ingredient{Quantity: 500, Unit: "g", Name: "flour"}},
+		{"500g flour", Ingredient{Quantity: 500, Unit: "g", Name: "flour"}},
+		{"1,5 l milk", Ingredient{Quantity: 1.5, Unit: "l", Name: "milk"}},
+		{"1/2 cup sugar", Ingredient{Quantity: 0.5, Unit: "cup", Name: "sugar"}},
+		{"2 eggs", Ingredient{Quantity: 2, Name: "eggs"}},
+		{"3 cloves garlic", Ingredient{Quantity: 3, Name: "cloves garlic"}},
+		{"salt", Ingredient{Name: "salt"}},
+		{"  pepper  ", Ingredient{Name: "pepper"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := parseText(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseText(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+		})
+	}
+	if _, err := parseText("  "); err == nil {
+		t.Error("parseText of a blank line must fail")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []Ingredient
+		want []Ingredient
+	}{
+		{
+			name: "same unit is kept",
+			in:   []Ingredient{{Quantity: 200, Unit: "g", Name: "flour"}, {Quantity: 300, Unit: "g", Name: "Flour"}},
+			want: []Ingredient{{Quantity: 500, Unit: "g", Name: "flour"}},
+		},
+		{
+			name: "mixed mass is summed in grams",
+			in:   []Ingredient{{Quantity: 0.5, Unit: "kg", Name: "flour"}, {Quantity: 200, Unit: "g", Name: "flour"}},
+			want: []Ingredient{{Quantity: 700, Unit: "g", Name: "flour"}},
+		},
+		{
+			name: "large mixed mass is summed in kilograms",
+			in:   []Ingredient{{Quantity: 1, Unit: "kg", Name: "flour"}, {Quantity: 500, Unit: "g", Name: "flour"}},
+			want: []Ingredient{{Quantity: 1.5, Unit: "kg", Name: "flour"}},
+		},
+		{
+			name: "mixed volume is summed in liters",
+			in:   []Ingredient{{Quantity: 1, Unit: "l", Name: "milk"}, {Quantity: 250, Unit: "ml", Name: "milk"}},
+			want: []Ingredient{{Quantity: 1.25, Unit: "l", Name: "milk"}},
+		},
+		{
+			name: "small mixed volume is summed in milliliters",
+			in:   []Ingredient{{Quantity: 1, Unit: "tbsp", Name: "oil"}, {Quantity: 1, Unit: "tsp", Name: "oil"}},
+			want: []Ingredient{{Quantity: 19.72, Unit: "ml", Name: "oil"}},
+		},
+		{
+			name: "incompatible units are kept apart",
+			in:   []Ingredient{{Quantity: 1, Unit: "cup", Name: "rice"}, {Quantity: 200, Unit: "g", Name: "rice"}, {Quantity: 1, Unit: "cup", Name: "rice"}},
+			want: []Ingredient{{Quantity: 2, Unit: "cup", Name: "rice"}, {Quantity: 200, Unit: "g", Name: "rice"}},
+		},
+		{
+			name: "counts and unknown units",
+			in:   []Ingredient{{Quantity: 2, Name: "eggs"}, {Quantity: 2, Unit: "clove", Name: "garlic"}, {Quantity: 1, Unit: "pc", Name: "eggs"}, {Quantity: 1, Unit: "clove", Name: "garlic"}},
+			want: []Ingredient{{Quantity: 3, Name: "eggs"}, {Quantity: 3, Unit: "clove", Name: "garlic"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Merge(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merge() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
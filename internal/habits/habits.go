@@ -0,0 +1,347 @@
+package habits
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/ical"
+	"github.com/biozz/wow/notebase/internal/periodic"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const habitType = "habit"
+
+type HabitsHandler struct {
+	app      *pocketbase.PocketBase
+	conf     *config.NotebaseConfig
+	periodic *periodic.PeriodicHandler
+}
+
+// Habit is a note of the habit type. Its occurrences are the `dates` list
+// and the daily notes, which set the `property` key to a truthy value or
+// have a checked task containing the `checkbox` text.
+type Habit struct {
+	Record   *core.Record
+	Summary  string
+	Schedule string
+	Property string
+	Checkbox string
+	// Start is the `start` key, or the first occurrence, or the creation of
+	// the note, whichever is set.
+	Start time.Time
+	Dates []time.Time
+
+	schedule Schedule
+	err      error
+}
+
+var ErrNotHabit = errors.New("note is not a habit")
+
+var checkedRe = regexp.MustCompile(`^\s*[-*+] \[[xX]\] (.*)$`)
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig, periodicHandler *periodic.PeriodicHandler) *HabitsHandler {
+	return &HabitsHandler{
+		app:      app,
+		conf:     conf,
+		periodic: periodicHandler,
+	}
+}
+
+func (h *HabitsHandler) parse(record *core.Record) (*Habit, error) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if fm.GetString("type") != habitType {
+		return nil, ErrNotHabit
+	}
+	habit := &Habit{
+		Record:   record,
+		Summary:  fm.GetString("summary"),
+		Schedule: fm.GetString("schedule"),
+		Property: fm.GetString("property"),
+		Checkbox: fm.GetString("checkbox"),
+	}
+	if habit.Summary == "" {
+		habit.Summary = templates.Title(record.GetString("path"))
+	}
+	habit.schedule, habit.err = ParseSchedule(habit.Schedule)
+	loc := h.periodic.Location()
+	if value := fm.GetString("start"); value != "" {
+		start, ok := utils.ParseDate(value, loc)
+		if !ok {
+			habit.err = fmt.Errorf("invalid start %q", value)
+		}
+		habit.Start = day(start)
+	}
+	value, _ := fm.Get("dates")
+	dates, _ := value.([]any)
+	for _, item := range dates {
+		date, ok := utils.ParseDate(fmt.Sprint(item), loc)
+		if !ok {
+			habit.err = fmt.Errorf("invalid date %q", item)
+			continue
+		}
+		habit.Dates = append(habit.Dates, day(date))
+	}
+	return habit, nil
+}
+
+// dailyOccurrences adds the occurrences found in the daily notes.
+func (h *HabitsHandler) dailyOccurrences(habits []*Habit) error {
+	if !slices.ContainsFunc(habits, func(habit *Habit) bool { return habit.Property != "" || habit.Checkbox != "" }) {
+		return nil
+	}
+	filter := "deleted = ''"
+	params := dbx.Params{}
+	if folder := h.conf.Periodic.Daily.Folder; folder != "" {
+		filter += " && path ~ {:folder}"
+		params["folder"] = utils.EscapeLike(strings.TrimSuffix(folder, "/")+"/") + "%"
+	}
+	records, err := h.app.FindRecordsByFilter("files", filter, "path", 0, 0, params)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		date, ok := h.periodic.Date(periodic.Daily, record.GetString("path"))
+		if !ok {
+			continue
+		}
+		fm, err := frontmatter.FromRecord(record)
+		if err != nil {
+			continue
+		}
+		var checked []string
+		for _, line := range strings.Split(record.GetString("content"), "\n") {
+			if m := checkedRe.FindStringSubmatch(line); m != nil {
+				checked = append(checked, strings.ToLower(m[1]))
+			}
+		}
+		for _, habit := range habits {
+			found := habit.Property != "" && truthy(fm, habit.Property)
+			if !found && habit.Checkbox != "" {
+				checkbox := strings.ToLower(habit.Checkbox)
+				found = slices.ContainsFunc(checked, func(text string) bool { return strings.Contains(text, checkbox) })
+			}
+			if found {
+				habit.Dates = append(habit.Dates, date)
+			}
+		}
+	}
+	return nil
+}
+
+func truthy(fm *frontmatter.Frontmatter, key string) bool {
+	value, _ := fm.Get(key)
+	switch v := value.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "", "false", "no", "0":
+			return false
+		}
+		return true
+	case []any:
+		return len(v) > 0
+	case map[string]any:
+		return len(v) > 0
+	}
+	return false
+}
+
+// Habits loads the habit notes with their occurrences, fileId limits them to
+// a single note.
+func (h *HabitsHandler) Habits(fileId string) ([]*Habit, error) {
+	var records []*core.Record
+	if fileId != "" {
+		record, err := h.app.FindRecordById("files", fileId)
+		if err != nil || record.GetString("deleted") != "" {
+			return nil, errors.New("file not found")
+		}
+		records = []*core.Record{record}
+	} else {
+		var err error
+		records, err = h.app.FindRecordsByFilter("files", "frontmatter.type = 'habit' && deleted = ''", "path", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	habits := []*Habit{}
+	for _, record := range records {
+		habit, err := h.parse(record)
+		if err != nil {
+			if fileId != "" {
+				return nil, err
+			}
+			continue
+		}
+		habits = append(habits, habit)
+	}
+	if err := h.dailyOccurrences(habits); err != nil {
+		return nil, err
+	}
+	for _, habit := range habits {
+		slices.SortFunc(habit.Dates, func(a, b time.Time) int { return a.Compare(b) })
+		if habit.Start.IsZero() {
+			habit.Start = day(habit.Record.GetDateTime("created").Time().In(h.periodic.Location()))
+			if len(habit.Dates) > 0 && habit.Dates[0].Before(habit.Start) {
+				habit.Start = habit.Dates[0]
+			}
+		}
+	}
+	return habits, nil
+}
+
+func (h *Habit) stats() Stats {
+	stats := Stats{
+		Id:       h.Record.Id,
+		Path:     h.Record.GetString("path"),
+		Summary:  h.Summary,
+		Schedule: h.Schedule,
+		Rates:    []Rate{},
+		Missed:   []Miss{},
+		Dates:    []string{},
+	}
+	if h.err != nil {
+		stats.Error = h.err.Error()
+	}
+	return stats
+}
+
+// Stats computes the stats of every habit, a habit with an invalid schedule
+// only reports the error.
+func (h *HabitsHandler) Stats(habits []*Habit, window Window) []Stats {
+	today := day(time.Now().In(h.periodic.Location()))
+	result := []Stats{}
+	for _, habit := range habits {
+		if habit.err != nil {
+			result = append(result, habit.stats())
+			continue
+		}
+		result = append(result, Compute(habit, h.periodic, today, window))
+	}
+	return result
+}
+
+// window reads the window from the query, by default it is the last 12
+// weeks or months up to today.
+func (h *HabitsHandler) window(e *core.RequestEvent) (Window, error) {
+	query := e.Request.URL.Query()
+	loc := h.periodic.Location()
+	window := Window{Interval: periodic.Weekly, To: day(time.Now().In(loc))}
+	switch query.Get("interval") {
+	case "", "week", periodic.Weekly:
+	case "month", periodic.Monthly:
+		window.Interval = periodic.Monthly
+	default:
+		return window, errors.New("interval must be week or month")
+	}
+	if value := query.Get("to"); value != "" {
+		to, ok := utils.ParseDate(value, loc)
+		if !ok {
+			return window, errors.New("invalid to date")
+		}
+		window.To = day(to)
+	}
+	window.From = h.periodic.Add(window.Interval, h.periodic.Start(window.Interval, window.To), -11)
+	if value := query.Get("from"); value != "" {
+		from, ok := utils.ParseDate(value, loc)
+		if !ok {
+			return window, errors.New("invalid from date")
+		}
+		window.From = day(from)
+	}
+	if window.From.After(window.To) {
+		return window, errors.New("from is after to")
+	}
+	return window, nil
+}
+
+// Calendar exports every occurrence as an all-day event.
+func (h *HabitsHandler) Calendar(habits []*Habit) ical.Component {
+	calendar := ical.NewCalendar("Habits")
+	now := ical.DateTime(time.Now())
+	for _, habit := range habits {
+		for _, date := range slices.Compact(habit.Dates) {
+			event := ical.Component{Name: "VEVENT"}
+			event.Add("UID", fmt.Sprintf("%s-%s@notebase", habit.Record.Id, date.Format("20060102")))
+			event.Add("DTSTAMP", now)
+			event.Add("DTSTART", ical.Date(date), ical.Param{Name: "VALUE", Value: "DATE"})
+			event.Add("DTEND", ical.Date(date.AddDate(0, 0, 1)), ical.Param{Name: "VALUE", Value: "DATE"})
+			event.Add("SUMMARY", ical.Text(habit.Summary))
+			event.Add("CATEGORIES", "HABIT")
+			event.Add("TRANSP", "TRANSPARENT")
+			calendar.Components = append(calendar.Components, event)
+		}
+	}
+	return calendar
+}
+
+func (h *HabitsHandler) Routes(se *core.ServeEvent) {
+	habitsGroup := se.Router.Group("/habits")
+	habitsGroup.Bind(apis.RequireSuperuserAuth())
+	habitsGroup.GET("", func(e *core.RequestEvent) error {
+		return h.handleStats(e, "")
+	})
+	habitsGroup.GET("/calendar.ics", func(e *core.RequestEvent) error {
+		return h.handleCalendar(e, "")
+	})
+	habitsGroup.GET("/{id}", func(e *core.RequestEvent) error {
+		return h.handleStats(e, e.Request.PathValue("id"))
+	})
+	habitsGroup.GET("/{id}/calendar.ics", func(e *core.RequestEvent) error {
+		return h.handleCalendar(e, e.Request.PathValue("id"))
+	})
+}
+
+func (h *HabitsHandler) handleStats(e *core.RequestEvent, fileId string) error {
+	window, err := h.window(e)
+	if err != nil {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	habits, err := h.Habits(fileId)
+	if err != nil {
+		return habitsError(err)
+	}
+	stats := h.Stats(habits, window)
+	if fileId != "" {
+		return e.JSON(http.StatusOK, stats[0])
+	}
+	return e.JSON(http.StatusOK, stats)
+}
+
+func (h *HabitsHandler) handleCalendar(e *core.RequestEvent, fileId string) error {
+	habits, err := h.Habits(fileId)
+	if err != nil {
+		return habitsError(err)
+	}
+	e.Response.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	e.Response.WriteHeader(http.StatusOK)
+	return h.Calendar(habits).Encode(e.Response)
+}
+
+func habitsError(err error) error {
+	if errors.Is(err, ErrNotHabit) {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	return apis.NewNotFoundError(err.Error(), nil)
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
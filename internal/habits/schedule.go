@@ -0,0 +1,71 @@
+package habits
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/periodic"
+	"github.com/biozz/wow/notebase/internal/recurrence"
+)
+
+// Schedule is either a frequency, e.g. `3x per week`, which is met by any
+// Times occurrences within a week or a month, or a recurrence rule,
+// e.g. `every weekday`, which expects an occurrence on every day of the rule.
+type Schedule struct {
+	Times  int
+	Period string
+	Rule   recurrence.Rule
+}
+
+var frequencyRe = regexp.MustCompile(`^(\d+|once|twice)\s*(?:x|times?)?\s+(?:per|a|an|every)\s+(week|month)$`)
+
+func ParseSchedule(value string) (Schedule, error) {
+	text := strings.ToLower(strings.TrimSpace(value))
+	switch text {
+	case "", "daily":
+		return Schedule{Rule: recurrence.Rule{Freq: recurrence.Daily, Interval: 1}}, nil
+	case "weekly":
+		return Schedule{Times: 1, Period: periodic.Weekly}, nil
+	case "monthly":
+		return Schedule{Times: 1, Period: periodic.Monthly}, nil
+	}
+	if m := frequencyRe.FindStringSubmatch(text); m != nil {
+		schedule := Schedule{Period: periodic.Weekly}
+		if m[2] == "month" {
+			schedule.Period = periodic.Monthly
+		}
+		switch m[1] {
+		case "once":
+			schedule.Times = 1
+		case "twice":
+			schedule.Times = 2
+		default:
+			schedule.Times, _ = strconv.Atoi(m[1])
+		}
+		if schedule.Times < 1 {
+			return schedule, fmt.Errorf("invalid schedule %q", value)
+		}
+		return schedule, nil
+	}
+	rule, err := recurrence.Parse(value)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid schedule: %w", err)
+	}
+	return Schedule{Rule: rule}, nil
+}
+
+func (s Schedule) Frequency() bool {
+	return s.Times > 0
+}
+
+// first returns the first day of the rule on or after start. Rules without
+// days are anchored at start itself.
+func (s Schedule) first(start time.Time) time.Time {
+	if len(s.Rule.ByDay) == 0 && len(s.Rule.ByMonthDay) == 0 {
+		return start
+	}
+	return s.Rule.Next(start.AddDate(0, 0, -1))
+}
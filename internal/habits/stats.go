@@ -0,0 +1,181 @@
+package habits
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/periodic"
+	"github.com/biozz/wow/notebase/internal/utils"
+)
+
+// Calendar splits time into weeks and months. The periodic notes handler
+// implements it, so that habits and weekly notes agree on the first day of
+// the week.
+type Calendar interface {
+	Start(name string, t time.Time) time.Time
+	Add(name string, start time.Time, n int) time.Time
+}
+
+type Stats struct {
+	Id       string `json:"id"`
+	Path     string `json:"path"`
+	Summary  string `json:"summary"`
+	Schedule string `json:"schedule"`
+	Error    string `json:"error,omitempty"`
+	// Unit of the streaks, which is a day for rule schedules and a week or
+	// a month for frequency schedules.
+	Unit          string `json:"unit"`
+	CurrentStreak int    `json:"current_streak"`
+	LongestStreak int    `json:"longest_streak"`
+	Total         int    `json:"total"`
+	Last          string `json:"last"`
+	// Rates, Missed and Dates are limited to the requested window.
+	Rates  []Rate   `json:"rates"`
+	Missed []Miss   `json:"missed"`
+	Dates  []string `json:"dates"`
+}
+
+// Rate is the completion rate of a week or a month. Expected is fractional
+// for frequency schedules, e.g. `3x per week` expects 3/7 per day.
+type Rate struct {
+	Start    string  `json:"start"`
+	End      string  `json:"end"`
+	Done     int     `json:"done"`
+	Expected float64 `json:"expected"`
+	Rate     float64 `json:"rate"`
+}
+
+// Miss is a day of a rule schedule or a period of a frequency schedule,
+// which has Missing occurrences less than expected.
+type Miss struct {
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Missing int    `json:"missing"`
+}
+
+// Window limits the reported rates, misses and dates, streaks are always
+// computed over the whole history.
+type Window struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+}
+
+// Compute computes the stats of the habit up to today, which is the start of
+// the current day. The current day or period does not break a streak, until
+// it is over.
+func Compute(habit *Habit, cal Calendar, today time.Time, window Window) Stats {
+	stats := habit.stats()
+	done := map[string]bool{}
+	for _, date := range habit.Dates {
+		done[dateKey(date)] = true
+	}
+	stats.Total = len(done)
+	for date := range done {
+		stats.Last = max(stats.Last, date)
+	}
+	inWindow := func(t time.Time) bool {
+		return !t.Before(window.From) && !t.After(window.To)
+	}
+	for _, date := range habit.Dates {
+		if inWindow(date) && !slices.Contains(stats.Dates, dateKey(date)) {
+			stats.Dates = append(stats.Dates, dateKey(date))
+		}
+	}
+	slices.Sort(stats.Dates)
+
+	schedule := habit.schedule
+	expected := map[string]bool{}
+	streak := 0
+	count := func(met bool, current bool) {
+		switch {
+		case met:
+			streak++
+			stats.LongestStreak = max(stats.LongestStreak, streak)
+		case !current:
+			streak = 0
+		}
+	}
+	if schedule.Frequency() {
+		stats.Unit = unit(schedule.Period)
+		current := cal.Start(schedule.Period, today)
+		for start := cal.Start(schedule.Period, habit.Start); !start.After(current); start = cal.Add(schedule.Period, start, 1) {
+			end := cal.Add(schedule.Period, start, 1)
+			n := countDone(done, start, end)
+			isCurrent := start.Equal(current)
+			count(n >= schedule.Times, isCurrent)
+			if n < schedule.Times && !isCurrent && inWindow(start) {
+				stats.Missed = append(stats.Missed, Miss{Start: dateKey(start), End: dateKey(end.AddDate(0, 0, -1)), Missing: schedule.Times - n})
+			}
+		}
+	} else {
+		stats.Unit = "day"
+		for day := schedule.first(habit.Start); !day.After(today); day = schedule.Rule.Next(day) {
+			expected[dateKey(day)] = true
+			isCurrent := day.Equal(today)
+			count(done[dateKey(day)], isCurrent)
+			if !done[dateKey(day)] && !isCurrent && inWindow(day) {
+				stats.Missed = append(stats.Missed, Miss{Start: dateKey(day), End: dateKey(day), Missing: 1})
+			}
+		}
+	}
+	stats.CurrentStreak = streak
+
+	// expectation of a single day, the current day only counts when it is done
+	expectedOn := func(day time.Time) float64 {
+		if day.Before(habit.Start) || day.After(today) || (day.Equal(today) && !done[dateKey(day)]) {
+			return 0
+		}
+		if !schedule.Frequency() {
+			if expected[dateKey(day)] {
+				return 1
+			}
+			return 0
+		}
+		start := cal.Start(schedule.Period, day)
+		days := cal.Add(schedule.Period, start, 1).Sub(start).Hours() / 24
+		return float64(schedule.Times) / math.Round(days)
+	}
+	from := cal.Start(window.Interval, window.From)
+	if habit.Start.After(window.From) {
+		from = cal.Start(window.Interval, habit.Start)
+	}
+	for start := from; !start.After(window.To) && !start.After(today); start = cal.Add(window.Interval, start, 1) {
+		end := cal.Add(window.Interval, start, 1)
+		rate := Rate{Start: dateKey(start), End: dateKey(end.AddDate(0, 0, -1)), Done: countDone(done, start, end)}
+		for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+			rate.Expected += expectedOn(day)
+		}
+		if rate.Expected > 0 {
+			rate.Rate = utils.Round(min(float64(rate.Done)/rate.Expected, 1))
+		}
+		rate.Expected = utils.Round(rate.Expected)
+		stats.Rates = append(stats.Rates, rate)
+	}
+	return stats
+}
+
+func countDone(done map[string]bool, start, end time.Time) int {
+	n := 0
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		if done[dateKey(day)] {
+			n++
+		}
+	}
+	return n
+}
+
+func unit(period string) string {
+	switch period {
+	case periodic.Weekly:
+		return "week"
+	case periodic.Monthly:
+		return "month"
+	}
+	return "day"
+}
+
+func dateKey(t time.Time) string {
+	return t.Format(time.DateOnly)
+}
@@ -0,0 +1,151 @@
+package habits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/periodic"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func date(value string) time.Time {
+	t, err := time.ParseInLocation(time.DateOnly, value, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestComputeStreaks(t *testing.T) {
+	conf := &config.NotebaseConfig{}
+	conf.Periodic.Timezone = "UTC"
+	conf.Periodic.Weekly.Format = "GGGG-[W]WW"
+	cal, err := periodic.NewHandler(nil, "", conf, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		schedule string
+		start    string
+		dates    []string
+		today    string
+		unit     string
+		current  int
+		longest  int
+	}{
+		{
+			name:     "today is not done yet",
+			schedule: "daily",
+			start:    "2026-10-01",
+			dates:    []string{"2026-10-01", "2026-10-02", "2026-10-03", "2026-10-04", "2026-10-05"},
+			today:    "2026-10-06",
+			unit:     "day",
+			current:  5,
+			longest:  5,
+		},
+		{
+			name:     "today is done",
+			schedule: "daily",
+			start:    "2026-10-01",
+			dates:    []string{"2026-10-01", "2026-10-02", "2026-10-03", "2026-10-04", "2026-10-05", "2026-10-06"},
+			today:    "2026-10-06",
+			unit:     "day",
+			current:  6,
+			longest:  6,
+		},
+		{
+			name:     "missed day breaks the streak",
+			schedule: "daily",
+			start:    "2026-10-01",
+			dates:    []string{"2026-10-01", "2026-10-02", "2026-10-03", "2026-10-05"},
+			today:    "2026-10-06",
+			unit:     "day",
+			current:  1,
+			longest:  3,
+		},
+		{
+			name:     "weekdays skip the weekend",
+			schedule: "every weekday",
+			start:    "2026-10-08",
+			dates:    []string{"2026-10-08", "2026-10-09", "2026-10-12"},
+			today:    "2026-10-13",
+			unit:     "day",
+			current:  3,
+			longest:  3,
+		},
+		{
+			name:     "current week is not over",
+			schedule: "2x per week",
+			start:    "2026-09-28",
+			dates:    []string{"2026-09-29", "2026-10-01", "2026-10-06", "2026-10-08", "2026-10-13", "2026-10-14"},
+			today:    "2026-10-20",
+			unit:     "week",
+			current:  3,
+			longest:  3,
+		},
+		{
+			name:     "current week is met",
+			schedule: "2x per week",
+			start:    "2026-09-28",
+			dates:    []string{"2026-09-29", "2026-10-01", "2026-10-06", "2026-10-08", "2026-10-13", "2026-10-14", "2026-10-19", "2026-10-20"},
+			today:    "2026-10-20",
+			unit:     "week",
+			current:  4,
+			longest:  4,
+		},
+		{
+			name:     "missed week breaks the streak",
+			schedule: "2x per week",
+			start:    "2026-09-28",
+			dates:    []string{"2026-09-29", "2026-10-01", "2026-10-06", "2026-10-13", "2026-10-14"},
+			today:    "2026-10-20",
+			unit:     "week",
+			current:  1,
+			longest:  1,
+		},
+		{
+			name:     "monthly",
+			schedule: "once a month",
+			start:    "2026-08-01",
+			dates:    []string{"2026-08-15", "2026-09-03"},
+			today:    "2026-10-19",
+			unit:     "month",
+			current:  2,
+			longest:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			habit := &Habit{
+				Record:   core.NewRecord(core.NewBaseCollection("files")),
+				Schedule: tt.schedule,
+				Start:    date(tt.start),
+				schedule: schedule,
+			}
+			for _, value := range tt.dates {
+				habit.Dates = append(habit.Dates, date(value))
+			}
+			today := date(tt.today)
+			stats := Compute(habit, cal, today, Window{From: habit.Start, To: today, Interval: periodic.Weekly})
+			if stats.Unit != tt.unit {
+				t.Errorf("unit = %q, want %q", stats.Unit, tt.unit)
+			}
+			if stats.CurrentStreak != tt.current {
+				t.Errorf("current streak = %d, want %d", stats.CurrentStreak, tt.current)
+			}
+			if stats.LongestStreak != tt.longest {
+				t.Errorf("longest streak = %d, want %d", stats.LongestStreak, tt.longest)
+			}
+			if stats.Total != len(tt.dates) {
+				t.Errorf("total = %d, want %d", stats.Total, len(tt.dates))
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Decode parses the first component of iCalendar data, usually a VCALENDAR.
+// Values are kept as is, use Unescape and Prop.Time to read them.
+func Decode(r io.Reader) (Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return Component{}, err
+	}
+	stack := []*Component{}
+	for _, line := range lines {
+		prop, err := parseLine(line)
+		if err != nil {
+			return Component{}, err
+		}
+		switch prop.Name {
+		case "BEGIN":
+			stack = append(stack, &Component{Name: strings.ToUpper(prop.Value)})
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return Component{}, fmt.Errorf("unexpected END:%s", prop.Value)
+			}
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return *c, nil
+			}
+			parent := stack[len(stack)-1]
+			parent.Components = append(parent.Components, *c)
+		default:
+			if len(stack) == 0 {
+				return Component{}, fmt.Errorf("property %s outside of a component", prop.Name)
+			}
+			c := stack[len(stack)-1]
+			c.Props = append(c.Props, prop)
+		}
+	}
+	return Component{}, fmt.Errorf("no complete component")
+}
+
+// unfold joins the folded lines, which start with a space or a tab.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+func parseLine(line string) (Prop, error) {
+	// the value starts at the first colon outside of a quoted parameter
+	quoted, colon := false, -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return Prop{}, fmt.Errorf("invalid line %q", line)
+	}
+	head, value := line[:colon], line[colon+1:]
+	parts := splitParams(head)
+	prop := Prop{Name: strings.ToUpper(parts[0]), Value: value}
+	for _, part := range parts[1:] {
+		name, paramValue, _ := strings.Cut(part, "=")
+		prop.Params = append(prop.Params, Param{
+			Name:  strings.ToUpper(name),
+			Value: strings.Trim(paramValue, `"`),
+		})
+	}
+	return prop, nil
+}
+
+func splitParams(head string) []string {
+	parts := []string{}
+	quoted, start := false, 0
+	for i, r := range head {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			parts = append(parts, head[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, head[start:])
+}
+
+// Find returns the first child component with the name.
+func (c Component) Find(name string) (Component, bool) {
+	for _, child := range c.Components {
+		if child.Name == name {
+			return child, true
+		}
+	}
+	return Component{}, false
+}
+
+// Prop returns the first property with the name.
+func (c Component) Prop(name string) (Prop, bool) {
+	for _, prop := range c.Props {
+		if prop.Name == name {
+			return prop, true
+		}
+	}
+	return Prop{}, false
+}
+
+// Param returns the value of the parameter with the name.
+func (p Prop) Param(name string) string {
+	for _, param := range p.Params {
+		if param.Name == name {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+// Time parses a DATE or DATE-TIME value, allDay is set for DATE values.
+// Floating times and unknown TZIDs are interpreted in loc.
+func (p Prop) Time(loc *time.Location) (t time.Time, allDay bool, err error) {
+	value := strings.TrimSpace(p.Value)
+	if p.Param("VALUE") == "DATE" || len(value) == len("20060102") {
+		t, err = time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	if tzid := p.Param("TZID"); tzid != "" {
+		if tz, err := time.LoadLocation(tzid); err == nil {
+			loc = tz
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// Unescape reads a TEXT value.
+func Unescape(value string) string {
+	return strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n").Replace(value)
+}
@@ -0,0 +1,122 @@
+// Package ical encodes and decodes iCalendar (RFC 5545) data.
+package ical
+
+import (
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const ProdID = "-//notebase//notebase//EN"
+
+type Param struct {
+	Name  string
+	Value string
+}
+
+type Prop struct {
+	Name   string
+	Params []Param
+	Value  string
+}
+
+// Component is a calendar component, e.g. VCALENDAR, VEVENT or VTODO.
+// Values are written as is, use Text, Date and DateTime to format them.
+type Component struct {
+	Name       string
+	Props      []Prop
+	Components []Component
+}
+
+func NewCalendar(name string) Component {
+	calendar := Component{Name: "VCALENDAR"}
+	calendar.Add("VERSION", "2.0")
+	calendar.Add("PRODID", ProdID)
+	if name != "" {
+		calendar.Add("X-WR-CALNAME", Text(name))
+	}
+	return calendar
+}
+
+func (c *Component) Add(name, value string, params ...Param) {
+	c.Props = append(c.Props, Prop{Name: name, Params: params, Value: value})
+}
+
+// Get returns the value of the first property with the name.
+func (c Component) Get(name string) string {
+	for _, prop := range c.Props {
+		if prop.Name == name {
+			return prop.Value
+		}
+	}
+	return ""
+}
+
+func (c Component) Encode(w io.Writer) error {
+	var b strings.Builder
+	c.encode(&b)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (c Component) String() string {
+	var b strings.Builder
+	c.encode(&b)
+	return b.String()
+}
+
+func (c Component) encode(b *strings.Builder) {
+	writeLine(b, "BEGIN:"+c.Name)
+	for _, prop := range c.Props {
+		var line strings.Builder
+		line.WriteString(prop.Name)
+		for _, param := range prop.Params {
+			line.WriteString(";" + param.Name + "=" + paramValue(param.Value))
+		}
+		line.WriteString(":" + prop.Value)
+		writeLine(b, line.String())
+	}
+	for _, child := range c.Components {
+		child.encode(b)
+	}
+	writeLine(b, "END:"+c.Name)
+}
+
+// writeLine folds lines longer than 75 octets without splitting characters,
+// the continuation lines start with a space, so they hold 74 octets.
+func writeLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line + "\r\n")
+}
+
+func paramValue(value string) string {
+	if strings.ContainsAny(value, ":;,") {
+		return `"` + strings.ReplaceAll(value, `"`, "") + `"`
+	}
+	return value
+}
+
+// Text escapes a TEXT value.
+func Text(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// Date formats an all-day DATE value.
+func Date(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// DateTime formats a DATE-TIME value in UTC.
+func DateTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
@@ -0,0 +1,201 @@
+package moment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format formats t with a Moment.js format string, which is what Obsidian
+// uses for dates in templates and daily notes. Text in square brackets
+// is printed as is.
+func Format(t time.Time, layout string) string {
+	var b strings.Builder
+	for i := 0; i < len(layout); {
+		if layout[i] == '[' {
+			end := strings.IndexByte(layout[i:], ']')
+			if end > 0 {
+				b.WriteString(layout[i+1 : i+end])
+				i += end + 1
+				continue
+			}
+		}
+		token := match(layout[i:])
+		if token == "" {
+			b.WriteByte(layout[i])
+			i++
+			continue
+		}
+		b.WriteString(formatToken(t, token))
+		i += len(token)
+	}
+	return b.String()
+}
+
+// tokens are ordered so that the longest token wins.
+var tokens = []string{
+	"YYYY", "GGGG", "gggg", "YY", "GG", "gg",
+	"Q",
+	"MMMM", "MMM", "MM", "Mo", "M",
+	"DDDD", "DDDo", "DDD", "DD", "Do", "D",
+	"dddd", "ddd", "dd", "do", "d", "E", "e",
+	"WW", "Wo", "W", "ww", "wo", "w",
+	"HH", "H", "hh", "h", "kk", "k",
+	"mm", "m", "ss", "s", "SSS", "SS", "S",
+	"A", "a", "ZZ", "Z", "X", "x",
+}
+
+func match(s string) string {
+	for _, token := range tokens {
+		if strings.HasPrefix(s, token) {
+			return token
+		}
+	}
+	return ""
+}
+
+func formatToken(t time.Time, token string) string {
+	isoYear, isoWeek := t.ISOWeek()
+	// locale weeks start on Sunday and the first week contains January 1st,
+	// like in the default Moment.js locale
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	sunday := day.AddDate(0, 0, -int(day.Weekday()))
+	localeYear := sunday.AddDate(0, 0, 6).Year()
+	jan1 := time.Date(localeYear, 1, 1, 0, 0, 0, 0, time.UTC)
+	firstSunday := jan1.AddDate(0, 0, -int(jan1.Weekday()))
+	localeWeek := int(sunday.Sub(firstSunday).Hours())/(24*7) + 1
+	hour12 := t.Hour() % 12
+	if hour12 == 0 {
+		hour12 = 12
+	}
+	// k is 1-24, midnight is 24
+	hour24 := t.Hour()
+	if hour24 == 0 {
+		hour24 = 24
+	}
+	switch token {
+	case "YYYY":
+		return fmt.Sprintf("%04d", t.Year())
+	case "YY":
+		return fmt.Sprintf("%02d", t.Year()%100)
+	case "GGGG":
+		return fmt.Sprintf("%04d", isoYear)
+	case "GG":
+		return fmt.Sprintf("%02d", isoYear%100)
+	case "gggg":
+		return fmt.Sprintf("%04d", localeYear)
+	case "gg":
+		return fmt.Sprintf("%02d", localeYear%100)
+	case "Q":
+		return strconv.Itoa((int(t.Month())-1)/3 + 1)
+	case "MMMM":
+		return t.Month().String()
+	case "MMM":
+		return t.Month().String()[:3]
+	case "MM":
+		return fmt.Sprintf("%02d", int(t.Month()))
+	case "Mo":
+		return ordinal(int(t.Month()))
+	case "M":
+		return strconv.Itoa(int(t.Month()))
+	case "DDDD":
+		return fmt.Sprintf("%03d", t.YearDay())
+	case "DDDo":
+		return ordinal(t.YearDay())
+	case "DDD":
+		return strconv.Itoa(t.YearDay())
+	case "DD":
+		return fmt.Sprintf("%02d", t.Day())
+	case "Do":
+		return ordinal(t.Day())
+	case "D":
+		return strconv.Itoa(t.Day())
+	case "dddd":
+		return t.Weekday().String()
+	case "ddd":
+		return t.Weekday().String()[:3]
+	case "dd":
+		return t.Weekday().String()[:2]
+	case "do":
+		return ordinal(int(t.Weekday()))
+	case "d", "e":
+		return strconv.Itoa(int(t.Weekday()))
+	case "E":
+		return strconv.Itoa((int(t.Weekday())+6)%7 + 1)
+	case "WW":
+		return fmt.Sprintf("%02d", isoWeek)
+	case "Wo":
+		return ordinal(isoWeek)
+	case "W":
+		return strconv.Itoa(isoWeek)
+	case "ww":
+		return fmt.Sprintf("%02d", localeWeek)
+	case "wo":
+		return ordinal(localeWeek)
+	case "w":
+		return strconv.Itoa(localeWeek)
+	case "HH":
+		return fmt.Sprintf("%02d", t.Hour())
+	case "H":
+		return strconv.Itoa(t.Hour())
+	case "hh":
+		return fmt.Sprintf("%02d", hour12)
+	case "h":
+		return strconv.Itoa(hour12)
+	case "kk":
+		return fmt.Sprintf("%02d", hour24)
+	case "k":
+		return strconv.Itoa(hour24)
+	case "mm":
+		return fmt.Sprintf("%02d", t.Minute())
+	case "m":
+		return strconv.Itoa(t.Minute())
+	case "ss":
+		return fmt.Sprintf("%02d", t.Second())
+	case "s":
+		return strconv.Itoa(t.Second())
+	case "SSS":
+		return fmt.Sprintf("%03d", t.Nanosecond()/1e6)
+	case "SS":
+		return fmt.Sprintf("%02d", t.Nanosecond()/1e7)
+	case "S":
+		return strconv.Itoa(t.Nanosecond() / 1e8)
+	case "A":
+		if t.Hour() < 12 {
+			return "AM"
+		}
+		return "PM"
+	case "a":
+		if t.Hour() < 12 {
+			return "am"
+		}
+		return "pm"
+	case "ZZ":
+		return t.Format("-0700")
+	case "Z":
+		return t.Format("-07:00")
+	case "X":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "x":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	}
+	return token
+}
+
+func ordinal(n int) string {
+	suffix := "th"
+	switch n % 100 {
+	case 11, 12, 13:
+	default:
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(n) + suffix
+}
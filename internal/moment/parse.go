@@ -0,0 +1,57 @@
+package moment
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// goTokens are the Moment.js tokens, which have a Go layout equivalent.
+var goTokens = map[string]string{
+	"YYYY": "2006", "YY": "06",
+	"MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1",
+	"DDDD": "002", "DD": "02", "D": "2",
+	"dddd": "Monday", "ddd": "Mon",
+	"HH": "15", "hh": "03", "h": "3",
+	"mm": "04", "m": "4", "ss": "05", "s": "5",
+	"A": "PM", "a": "pm", "ZZ": "-0700", "Z": "-07:00",
+}
+
+// literalCheck is unlike the Go reference time in every element, so that a
+// literal, which Go would read as a layout element, formats differently.
+var literalCheck = time.Date(2001, time.November, 15, 9, 14, 16, 0, time.UTC)
+
+// Parse parses value with a Moment.js format. Only the tokens, which can be
+// parsed unambiguously, are supported, e.g. week numbers are not. Bracketed
+// literals are copied into the Go layout, so the ones, which contain Go
+// layout elements, e.g. `[Week 1]`, are rejected.
+func Parse(layout, value string, loc *time.Location) (time.Time, error) {
+	var b strings.Builder
+	for i := 0; i < len(layout); {
+		if layout[i] == '[' {
+			end := strings.IndexByte(layout[i:], ']')
+			if end > 0 {
+				literal := layout[i+1 : i+end]
+				if literalCheck.Format(literal) != literal {
+					return time.Time{}, fmt.Errorf("unsupported literal [%s]", literal)
+				}
+				b.WriteString(literal)
+				i += end + 1
+				continue
+			}
+		}
+		token := match(layout[i:])
+		if token == "" {
+			b.WriteByte(layout[i])
+			i++
+			continue
+		}
+		goToken, ok := goTokens[token]
+		if !ok {
+			return time.Time{}, fmt.Errorf("unsupported token %s", token)
+		}
+		b.WriteString(goToken)
+		i += len(token)
+	}
+	return time.ParseInLocation(b.String(), value, loc)
+}
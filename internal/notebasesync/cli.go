@@ -0,0 +1,370 @@
+package notebasesync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+// The offline commands work on pb_data and the vault directly. Every command
+// syncs the note from disk first, so that edits made while the server was
+// not running are not overwritten.
+
+// relPath resolves a note argument, which is a path relative to the notes
+// root, an absolute path inside of it or a record id.
+func (h *SyncHandler) relPath(arg string) (string, error) {
+	if filepath.IsAbs(arg) {
+		rel, err := filepath.Rel(h.root, arg)
+		if err != nil || !utils.Within(".", rel) {
+			return "", fmt.Errorf("%s is outside of the notes root", arg)
+		}
+		return rel, nil
+	}
+	if filepath.Ext(arg) != ".md" {
+		if record, err := h.app.FindRecordById("files", arg); err == nil {
+			return record.GetString("path"), nil
+		}
+		arg += ".md"
+	}
+	if !utils.Within(".", arg) {
+		return "", fmt.Errorf("%s is outside of the notes root", arg)
+	}
+	return filepath.Clean(arg), nil
+}
+
+// syncPath loads a single note from disk into the files table.
+func (h *SyncHandler) syncPath(relPath string) (*core.Record, error) {
+	absPath := filepath.Join(h.root, relPath)
+	if _, err := os.Stat(absPath); err != nil {
+		return nil, err
+	}
+	record, err := h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": relPath})
+	if err == nil && utils.GetDBHash(record.GetString("raw_frontmatter"), record.GetString("content")) == utils.GetFSHash(absPath) {
+		return record, nil
+	}
+	data, err := parse(h.root, absPath)
+	if err != nil {
+		return nil, err
+	}
+	return h.upsertFile(data)
+}
+
+// Note resolves a note argument and syncs the note from disk.
+func (h *SyncHandler) Note(arg string) (*core.Record, error) {
+	relPath, err := h.relPath(arg)
+	if err != nil {
+		return nil, err
+	}
+	return h.syncPath(relPath)
+}
+
+func (h *SyncHandler) LsCmd() *cobra.Command {
+	var (
+		filter string
+		sort   string
+		limit  int
+		asJSON bool
+	)
+	cmd := &cobra.Command{
+		Use:          "ls",
+		SilenceUsage: true,
+		Short:        "List notes matching a filter",
+		Example: `  notebase ls --filter "frontmatter.type = 'track'"
+  notebase ls --filter "path ~ 'activities/%'" --json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			expr := "deleted = ''"
+			if strings.TrimSpace(filter) != "" {
+				expr = "(" + filter + ") && deleted = ''"
+			}
+			records, err := h.app.FindRecordsByFilter("files", expr, sort, limit, 0)
+			if err != nil {
+				return err
+			}
+			if !asJSON {
+				for _, record := range records {
+					cmd.Println(record.GetString("path"))
+				}
+				return nil
+			}
+			notes := make([]map[string]any, 0, len(records))
+			for _, record := range records {
+				notes = append(notes, map[string]any{
+					"id":          record.Id,
+					"path":        record.GetString("path"),
+					"frontmatter": json.RawMessage(record.GetString("frontmatter")),
+				})
+			}
+			return printJSON(cmd.OutOrStdout(), notes)
+		},
+	}
+	cmd.Flags().StringVar(&filter, "filter", "", "PocketBase filter over the files collection")
+	cmd.Flags().StringVar(&sort, "sort", "path", "sort expression, e.g. -updated")
+	cmd.Flags().IntVar(&limit, "limit", 0, "maximum number of notes, 0 for all")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print id, path and frontmatter as JSON")
+	return cmd
+}
+
+func (h *SyncHandler) GetCmd() *cobra.Command {
+	var (
+		format string
+		only   string
+	)
+	cmd := &cobra.Command{
+		Use:          "get <note>",
+		SilenceUsage: true,
+		Short:        "Print the frontmatter and content of a note",
+		Args:         cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			relPath, err := h.relPath(args[0])
+			if err != nil {
+				return err
+			}
+			data, err := parse(h.root, filepath.Join(h.root, relPath))
+			if err != nil {
+				return err
+			}
+			fm, err := frontmatter.Parse(data.JSONFrontmatter)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			switch only {
+			case "content":
+				_, err := io.WriteString(out, data.Content)
+				return err
+			case "frontmatter", "":
+			default:
+				return fmt.Errorf("unknown part %q, expected frontmatter or content", only)
+			}
+
+			switch format {
+			case "json":
+				raw, err := fm.JSON()
+				if err != nil {
+					return err
+				}
+				if only == "frontmatter" {
+					return printJSON(out, json.RawMessage(raw))
+				}
+				return printJSON(out, struct {
+					Path        string          `json:"path"`
+					Frontmatter json.RawMessage `json:"frontmatter"`
+					Content     string          `json:"content"`
+				}{relPath, json.RawMessage(raw), data.Content})
+			case "yaml":
+				raw, err := fm.YAML()
+				if err != nil {
+					return err
+				}
+				if only == "frontmatter" {
+					_, err := io.WriteString(out, raw)
+					return err
+				}
+				items := yaml.MapSlice{}
+				if err := yaml.UnmarshalWithOptions([]byte(raw), &items, yaml.UseOrderedMap()); err != nil {
+					return err
+				}
+				b, err := yaml.MarshalWithOptions(yaml.MapSlice{
+					{Key: "path", Value: relPath},
+					{Key: "frontmatter", Value: items},
+					{Key: "content", Value: data.Content},
+				}, yaml.UseLiteralStyleIfMultiline(true))
+				if err != nil {
+					return err
+				}
+				_, err = out.Write(b)
+				return err
+			}
+			return fmt.Errorf("unknown format %q, expected json or yaml", format)
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "json", "output format, json or yaml")
+	cmd.Flags().StringVar(&only, "only", "", "print only the frontmatter or the content")
+	return cmd
+}
+
+func (h *SyncHandler) SetCmd() *cobra.Command {
+	var unset []string
+	cmd := &cobra.Command{
+		Use:          "set <note> [key=value...]",
+		SilenceUsage: true,
+		Short:        "Edit the frontmatter of a note, values are parsed as YAML",
+		Example: `  notebase set activities/lazarus.md episode=16 status=watching
+  notebase set activities/lazarus.md --unset next_episode`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 && len(unset) == 0 {
+				return errors.New("nothing to set")
+			}
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+			record, err := h.Note(args[0])
+			if err != nil {
+				return err
+			}
+			relPath := record.GetString("path")
+			fm, err := frontmatter.FromRecord(record)
+			if err != nil {
+				return err
+			}
+			if err := setPairs(fm, args[1:]); err != nil {
+				return err
+			}
+			for _, key := range unset {
+				fm.Delete(key)
+			}
+			if err := fm.Save(h.app, record); err != nil {
+				return err
+			}
+			h.CommitPending()
+			printValidationErrors(cmd, record)
+			cmd.Println(relPath)
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&unset, "unset", nil, "keys to remove")
+	return cmd
+}
+
+func (h *SyncHandler) NewCmd() *cobra.Command {
+	var content string
+	cmd := &cobra.Command{
+		Use:          "new <path> [key=value...]",
+		SilenceUsage: true,
+		Short:        "Create a note with the given frontmatter",
+		Example: `  notebase new activities/lazarus type=track tags=[anime] season=1
+  echo "- [ ] milk" | notebase new groceries/weekly type=groceries --content -`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+			relPath, err := h.relPath(args[0])
+			if err != nil {
+				return err
+			}
+			fm, err := frontmatter.Parse("")
+			if err != nil {
+				return err
+			}
+			if err := setPairs(fm, args[1:]); err != nil {
+				return err
+			}
+			if content == "-" {
+				b, err := io.ReadAll(cmd.InOrStdin())
+				if err != nil {
+					return err
+				}
+				content = string(b)
+			}
+			if content != "" && !strings.HasSuffix(content, "\n") {
+				content += "\n"
+			}
+
+			rawFrontmatter, err := fm.YAML()
+			if err != nil {
+				return err
+			}
+			record, err := h.CreateNote(relPath, rawFrontmatter, content)
+			if err != nil {
+				return err
+			}
+			h.CommitPending()
+			printValidationErrors(cmd, record)
+			cmd.Println(relPath)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&content, "content", "", "note content, - to read it from stdin")
+	return cmd
+}
+
+// CreateNote writes a new note to disk and loads it into the files table. It
+// is the only place, where the notes are created, so it also makes sure that
+// the path stays inside of the notes root.
+func (h *SyncHandler) CreateNote(relPath, rawFrontmatter, content string) (*core.Record, error) {
+	relPath = filepath.Clean(relPath)
+	if filepath.IsAbs(relPath) || relPath == "." || !utils.Within(".", relPath) {
+		return nil, errors.New("path must be relative to the notes root")
+	}
+	absPath := filepath.Join(h.root, relPath)
+	if _, err := os.Stat(absPath); err == nil {
+		return nil, fmt.Errorf("%s already exists", relPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := utils.SaveToDisk(absPath, content, rawFrontmatter); err != nil {
+		return nil, err
+	}
+	return h.syncPath(relPath)
+}
+
+// MoveNote renames the file of the note and syncs it under the new path, the
+// record of the old path is soft deleted.
+func (h *SyncHandler) MoveNote(record *core.Record, relPath string) (*core.Record, error) {
+	oldPath := filepath.Join(h.root, record.GetString("path"))
+	absPath := filepath.Join(h.root, relPath)
+	if _, err := os.Stat(absPath); err == nil {
+		return nil, fmt.Errorf("%s already exists", relPath)
+	}
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(oldPath, absPath); err != nil {
+		return nil, err
+	}
+	if err := h.softDeleteFile(oldPath); err != nil {
+		return nil, err
+	}
+	return h.syncPath(relPath)
+}
+
+// RemoveNote removes the file of the note and soft deletes its record.
+func (h *SyncHandler) RemoveNote(record *core.Record) error {
+	absPath := filepath.Join(h.root, record.GetString("path"))
+	if err := os.Remove(absPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return h.softDeleteFile(absPath)
+}
+
+func setPairs(fm *frontmatter.Frontmatter, pairs []string) error {
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid %q, expected key=value", pair)
+		}
+		fm.Set(key, frontmatter.ParseValue(value))
+	}
+	return nil
+}
+
+func printValidationErrors(cmd *cobra.Command, record *core.Record) {
+	errs := map[string]string{}
+	if err := record.UnmarshalJSONField("validation_errors", &errs); err != nil {
+		return
+	}
+	for key, msg := range errs {
+		cmd.PrintErrf("warning: %s: %s\n", key, msg)
+	}
+}
+
+func printJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/biozz/wow/notebase/internal/utils"
 	"github.com/gobwas/glob"
 	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/syncthing/notify"
 )
@@ -97,7 +98,7 @@ func (h *SyncHandler) handleFSNotifyEvent(event notify.EventInfo) {
 	case notify.Create, notify.Write:
 		data, err := parse(h.root, event.Path())
 		if err != nil {
-			h.app.Logger().Error("unable to parse", err)
+			h.app.Logger().Error("unable to parse", "error", err)
 			return
 		}
 		if event.Event() == notify.Create {
@@ -107,29 +108,52 @@ func (h *SyncHandler) handleFSNotifyEvent(event notify.EventInfo) {
 			}
 
 			if err := h.createFile(data); err != nil {
-				h.app.Logger().Error("unable to create file", err)
+				h.app.Logger().Error("unable to create file", "error", err)
 			}
 			return
 		}
 		if event.Event() == notify.Write {
 			if err := h.updateFile(data); err != nil {
-				h.app.Logger().Error("unable to write file", err)
+				h.app.Logger().Error("unable to write file", "error", err)
 			}
 			return
 		}
 	case notify.Rename, notify.Remove:
 		if err := h.softDeleteFile(event.Path()); err != nil {
-			h.app.Logger().Error("unable to delete file", err)
+			h.app.Logger().Error("unable to delete file", "error", err)
 		}
 	}
 }
 
 func (h *SyncHandler) createFile(data File) error {
-	filesCol, err := h.app.FindCollectionByNameOrId("files")
+	_, err := h.upsertFile(data)
+	return err
+}
+
+// upsertFile saves a parsed file. The existing record is reused, when the
+// path is already in the files table (e.g. editors, which save by replacing the file).
+// A failed save is retried once, since the record may have been created in
+// the meantime by another process working on the same vault, e.g. the CLI.
+func (h *SyncHandler) upsertFile(data File) (*core.Record, error) {
+	h.filesMu.Lock()
+	defer h.filesMu.Unlock()
+
+	fileRec, err := h.saveFile(data)
 	if err != nil {
-		return err
+		fileRec, err = h.saveFile(data)
+	}
+	return fileRec, err
+}
+
+func (h *SyncHandler) saveFile(data File) (*core.Record, error) {
+	fileRec, err := h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": data.RelPath})
+	if err != nil {
+		filesCol, err := h.app.FindCollectionByNameOrId("files")
+		if err != nil {
+			return nil, err
+		}
+		fileRec = core.NewRecord(filesCol)
 	}
-	fileRec := core.NewRecord(filesCol)
 	version := utils.GetVersion()
 
 	data.Origin = "fs"
@@ -143,12 +167,12 @@ func (h *SyncHandler) createFile(data File) error {
 
 	if err := h.app.Save(fileRec); err != nil {
 		h.app.Logger().Error("Error saving file record", "error", err)
-		return err
+		return nil, err
 	}
 
 	utils.SetFileXAttrs(data.AbsPath, utils.XAttrs{Version: version, Origin: "fs"})
 
-	return nil
+	return fileRec, nil
 }
 
 func fillFileRecFromData(fileRec *core.Record, data File) {
@@ -173,7 +197,7 @@ func (h *SyncHandler) updateFile(data File) error {
 	dbVersion, _ := time.Parse(time.RFC3339Nano, fileRec.GetString("version"))
 	fsVersion, _ := time.Parse(time.RFC3339Nano, xattrs.Version)
 
-	dbHash := utils.GetDBHash(fileRec.GetString("frontmatter"), fileRec.GetString("content"))
+	dbHash := utils.GetDBHash(fileRec.GetString("raw_frontmatter"), fileRec.GetString("content"))
 	fsHash := utils.GetFSHash(data.AbsPath)
 
 	if dbHash == fsHash {
@@ -208,6 +232,7 @@ func (h *SyncHandler) softDeleteFile(path string) error {
 		return err
 	}
 	fileRec.Set("deleted", time.Now())
+	fileRec.Set("origin", "fs")
 	if err := h.app.Save(fileRec); err != nil {
 		h.app.Logger().Error("Error deleting file record", "error", err)
 		return err
@@ -0,0 +1,220 @@
+package notebasesync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+type Commit struct {
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Email   string `json:"email"`
+	Date    string `json:"date"`
+	Subject string `json:"subject"`
+}
+
+// TrackChange queues a changed file for the next automatic commit.
+func (h *SyncHandler) TrackChange(record *core.Record) {
+	if !h.conf.Git.Enabled {
+		return
+	}
+	path := record.GetString("path")
+	origin := record.GetString("origin")
+	if origin == "" {
+		origin = "db"
+	}
+
+	h.gitMu.Lock()
+	if current, ok := h.gitPending[path]; !ok {
+		h.gitPending[path] = origin
+	} else if !slices.Contains(strings.Split(current, ","), origin) {
+		h.gitPending[path] = current + "," + origin
+	}
+	h.gitMu.Unlock()
+
+	// the manager only needs to know that something changed
+	select {
+	case h.gitChanged <- struct{}{}:
+	default:
+	}
+}
+
+// GitManager commits the tracked changes after the quiet period.
+func (h *SyncHandler) GitManager() {
+	if !h.conf.Git.Enabled {
+		return
+	}
+	if _, err := h.git("rev-parse", "--is-inside-work-tree"); err != nil {
+		h.app.Logger().Info("notes root is not a git repository, initializing it", "root", h.root)
+		if _, err := h.git("init"); err != nil {
+			h.app.Logger().Error("unable to initialize git repository", "error", err)
+			return
+		}
+	}
+
+	timer := time.NewTimer(h.conf.Git.QuietPeriod)
+	timer.Stop()
+	for {
+		select {
+		case <-h.gitChanged:
+			timer.Reset(h.conf.Git.QuietPeriod)
+		case <-timer.C:
+			h.CommitPending()
+		}
+	}
+}
+
+// CommitPending commits the tracked changes right away.
+func (h *SyncHandler) CommitPending() {
+	if !h.conf.Git.Enabled {
+		return
+	}
+	h.gitMu.Lock()
+	pending := h.gitPending
+	h.gitPending = map[string]string{}
+	h.gitMu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if err := h.commit(pending); err != nil {
+		h.app.Logger().Error("unable to commit changes", "error", err)
+	}
+}
+
+func (h *SyncHandler) commit(changes map[string]string) error {
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	existing, missing := []string{}, []string{}
+	for _, path := range paths {
+		if _, err := os.Stat(filepath.Join(h.root, path)); err == nil {
+			existing = append(existing, path)
+		} else {
+			missing = append(missing, path)
+		}
+	}
+	if len(existing) > 0 {
+		// `git add` fails on ignored files, so they are skipped
+		out, _ := h.git(append([]string{"check-ignore", "-z", "--"}, existing...)...)
+		ignored := map[string]bool{}
+		for _, path := range strings.Split(out, "\x00") {
+			ignored[path] = true
+		}
+		toAdd := []string{}
+		for _, path := range existing {
+			if !ignored[path] {
+				toAdd = append(toAdd, path)
+			}
+		}
+		if len(toAdd) > 0 {
+			if _, err := h.git(append([]string{"add", "-A", "--"}, toAdd...)...); err != nil {
+				return err
+			}
+		}
+	}
+	if len(missing) > 0 {
+		if _, err := h.git(append([]string{"rm", "--cached", "--ignore-unmatch", "-q", "--"}, missing...)...); err != nil {
+			return err
+		}
+	}
+
+	// only the tracked paths are committed, the rest of the index is left as is
+	out, err := h.git(append([]string{"diff", "--cached", "--name-status", "--no-renames", "--relative", "-z", "--"}, paths...)...)
+	if err != nil {
+		return err
+	}
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	committed := []string{}
+	body := []string{}
+	origins := map[string]bool{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		status, path := fields[i], fields[i+1]
+		committed = append(committed, path)
+		body = append(body, fmt.Sprintf("%s %s (%s)", status, path, changes[path]))
+		for _, origin := range strings.Split(changes[path], ",") {
+			origins[origin] = true
+		}
+	}
+	if len(committed) == 0 {
+		return nil
+	}
+
+	originList := make([]string, 0, len(origins))
+	for origin := range origins {
+		originList = append(originList, origin)
+	}
+	sort.Strings(originList)
+	subject := fmt.Sprintf("Update %d notes (%s)", len(committed), strings.Join(originList, ", "))
+	if len(committed) == 1 {
+		subject = fmt.Sprintf("Update %s (%s)", committed[0], strings.Join(originList, ", "))
+	}
+	message := subject + "\n\n" + strings.Join(body, "\n") + "\n"
+
+	if _, err := h.git(append([]string{"commit", "-q", "-m", message, "--"}, committed...)...); err != nil {
+		return err
+	}
+	h.app.Logger().Info("Committed notes", "count", len(committed))
+	return nil
+}
+
+// GitLog returns the commits of a single note, newest first.
+func (h *SyncHandler) GitLog(path string, limit int) ([]Commit, error) {
+	out, err := h.git("log", "--follow", "-n", strconv.Itoa(limit), "--format=%H%x1f%an%x1f%ae%x1f%aI%x1f%s", "--", path)
+	if err != nil {
+		return nil, err
+	}
+	commits := []Commit{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    fields[3],
+			Subject: fields[4],
+		})
+	}
+	return commits, nil
+}
+
+func (h *SyncHandler) git(args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", h.root}, args...)...)
+	cmd.Env = os.Environ()
+	if author, err := mail.ParseAddress(h.conf.Git.Author); err == nil {
+		cmd.Env = append(cmd.Env,
+			"GIT_AUTHOR_NAME="+author.Name,
+			"GIT_AUTHOR_EMAIL="+author.Address,
+			"GIT_COMMITTER_NAME="+author.Name,
+			"GIT_COMMITTER_EMAIL="+author.Address,
+		)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() > 0 {
+			return stdout.String(), fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return stdout.String(), err
+	}
+	return stdout.String(), nil
+}
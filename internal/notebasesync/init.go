@@ -7,7 +7,9 @@ import (
 	"time"
 
 	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 func (h *SyncHandler) InitialSync() {
@@ -18,7 +20,23 @@ func (h *SyncHandler) InitialSync() {
 	if h.conf.ClearOnStartup {
 		_, err := h.app.DB().NewQuery("DELETE FROM files").Execute()
 		if err != nil {
-			h.app.Logger().Error("unable to clear files table", err)
+			h.app.Logger().Error("unable to clear files table", "error", err)
+			return
+		}
+		// Relations are cascaded by PocketBase and not by SQLite, so dependent
+		// tables have to be cleared manually
+		_, err = h.app.DB().NewQuery("DELETE FROM properties").Execute()
+		if err != nil {
+			h.app.Logger().Error("unable to clear properties table", "error", err)
+			return
+		}
+		// The files are recreated under new ids, so the CalDAV resources of the
+		// old ones are reported to the clients as removed
+		_, err = h.app.DB().NewQuery("UPDATE caldav_resources SET removed = {:now} WHERE removed = ''").
+			Bind(dbx.Params{"now": types.NowDateTime().String()}).
+			Execute()
+		if err != nil {
+			h.app.Logger().Error("unable to remove caldav resources", "error", err)
 			return
 		}
 	}
@@ -61,7 +79,7 @@ func (h *SyncHandler) InitialSync() {
 	})
 
 	if err != nil {
-		h.app.Logger().Error("unable to walk files", err)
+		h.app.Logger().Error("unable to walk files", "error", err)
 	}
 
 	close(filesChan)
@@ -21,11 +21,14 @@ func (h *SyncHandler) OnRecordUpdate(record *core.Record) {
 	fsVersion, _ := time.Parse(time.RFC3339Nano, xattrs.Version)
 
 	frontmatterJSON := record.GetString("frontmatter")
-	newFrontmatter := utils.JsonToYaml(frontmatterJSON)
 	frontmatter := record.GetString("raw_frontmatter")
-	if newFrontmatter != frontmatter {
+	// The raw frontmatter is regenerated only when it no longer matches the
+	// JSON, so that formatting of the file is kept on content-only changes.
+	rawJSON, rawErr := utils.YamlToJson(frontmatter)
+	currentJSON, _ := utils.YamlToJson(frontmatterJSON)
+	if rawErr != nil || rawJSON != currentJSON {
 		h.app.Logger().Debug("frontmatter changed, updating it and exiting")
-		frontmatter = newFrontmatter
+		frontmatter = utils.JsonToYaml(frontmatterJSON)
 		record.Set("raw_frontmatter", frontmatter)
 		if err := h.app.Save(record); err != nil {
 			h.app.Logger().Error("error saving record", "error", err)
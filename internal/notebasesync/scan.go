@@ -0,0 +1,173 @@
+package notebasesync
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// SyncSummary is the result of a one-shot incremental sync.
+type SyncSummary struct {
+	Added     int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	Failed    int
+}
+
+// Drift is the difference between the notes on disk and the files table.
+type Drift struct {
+	// on disk, but not in the database
+	Missing []string
+	// in the database, but not on disk
+	Orphaned []string
+	// different content on disk and in the database
+	Modified []string
+}
+
+func (d Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Orphaned) == 0 && len(d.Modified) == 0
+}
+
+// markdownFiles returns the absolute paths of all notes, which are not excluded.
+func (h *SyncHandler) markdownFiles() ([]string, error) {
+	paths := []string{}
+	err := filepath.WalkDir(h.root, func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		p, _ := filepath.Rel(h.root, walkPath)
+		if utils.IsExcluded(h.excludePatters, p) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && filepath.Ext(walkPath) == ".md" {
+			paths = append(paths, walkPath)
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// liveRecords returns the files, which are not soft deleted, by path.
+func (h *SyncHandler) liveRecords() (map[string]*core.Record, error) {
+	records, err := h.app.FindRecordsByFilter("files", "deleted = ''", "", 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	byPath := make(map[string]*core.Record, len(records))
+	for _, record := range records {
+		byPath[record.GetString("path")] = record
+	}
+	return byPath, nil
+}
+
+// Sync brings the files table up to date with the disk. Unlike InitialSync it
+// only touches the files, which are new, changed or removed.
+func (h *SyncHandler) Sync() (SyncSummary, error) {
+	summary := SyncSummary{}
+	records, err := h.liveRecords()
+	if err != nil {
+		return summary, err
+	}
+	paths, err := h.markdownFiles()
+	if err != nil {
+		return summary, err
+	}
+	filesCol, err := h.app.FindCollectionByNameOrId("files")
+	if err != nil {
+		return summary, err
+	}
+
+	changed := []*core.Record{}
+	for _, absPath := range paths {
+		data, err := parse(h.root, absPath)
+		if err != nil {
+			h.app.Logger().Error("error parsing file", "path", absPath, "error", err)
+			summary.Failed++
+			continue
+		}
+		record, ok := records[data.RelPath]
+		delete(records, data.RelPath)
+		if ok && utils.GetDBHash(record.GetString("raw_frontmatter"), record.GetString("content")) == utils.GetFSHash(absPath) {
+			summary.Unchanged++
+			continue
+		}
+		if ok {
+			summary.Updated++
+		} else {
+			record = core.NewRecord(filesCol)
+			summary.Added++
+		}
+		data.Origin = "fs"
+		if data.Version == "" {
+			data.Version = utils.GetVersion()
+		}
+		fillFileRecFromData(record, data)
+		changed = append(changed, record)
+	}
+	for _, record := range records {
+		record.Set("deleted", time.Now())
+		record.Set("origin", "fs")
+		changed = append(changed, record)
+		summary.Deleted++
+	}
+
+	for start := 0; start < len(changed); start += h.conf.SyncBatchSize {
+		batch := changed[start:min(start+h.conf.SyncBatchSize, len(changed))]
+		err := h.app.RunInTransaction(func(txApp core.App) error {
+			for _, record := range batch {
+				if err := txApp.Save(record); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return summary, err
+		}
+		for _, record := range batch {
+			if record.GetString("deleted") == "" {
+				absPath := filepath.Join(h.root, record.GetString("path"))
+				utils.SetFileXAttrs(absPath, utils.XAttrs{Version: record.GetString("version"), Origin: "fs"})
+			}
+		}
+	}
+	return summary, nil
+}
+
+// Verify compares the disk and the files table without changing anything.
+func (h *SyncHandler) Verify() (Drift, error) {
+	drift := Drift{Missing: []string{}, Orphaned: []string{}, Modified: []string{}}
+	records, err := h.liveRecords()
+	if err != nil {
+		return drift, err
+	}
+	paths, err := h.markdownFiles()
+	if err != nil {
+		return drift, err
+	}
+	for _, absPath := range paths {
+		relPath, _ := filepath.Rel(h.root, absPath)
+		record, ok := records[relPath]
+		if !ok {
+			drift.Missing = append(drift.Missing, relPath)
+			continue
+		}
+		delete(records, relPath)
+		if utils.GetDBHash(record.GetString("raw_frontmatter"), record.GetString("content")) != utils.GetFSHash(absPath) {
+			drift.Modified = append(drift.Modified, relPath)
+		}
+	}
+	for path := range records {
+		drift.Orphaned = append(drift.Orphaned, path)
+	}
+	sort.Strings(drift.Orphaned)
+	return drift, nil
+}
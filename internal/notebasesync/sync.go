@@ -2,7 +2,15 @@ package notebasesync
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
 	"path"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/biozz/wow/notebase/internal/config"
 	"github.com/gobwas/glob"
@@ -20,8 +28,15 @@ type SyncHandler struct {
 	excludePatters []glob.Glob
 	conf           *config.NotebaseConfig
 
-	// watcher
+	// watcher, filesMu serializes the notes loaded by the watcher and by
+	// CreateNote, which race for the same path
 	fileChanges chan notify.EventInfo
+	filesMu     sync.Mutex
+
+	// git, changed paths with their origins
+	gitMu      sync.Mutex
+	gitPending map[string]string
+	gitChanged chan struct{}
 }
 
 func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseConfig) (*SyncHandler, error) {
@@ -30,15 +45,21 @@ func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseCo
 	for _, pattern := range conf.Exclude {
 		g, err := glob.Compile(pattern)
 		if err != nil {
-			return nil, fmt.Errorf("Invalid glob pattern", err)
+			return nil, fmt.Errorf("Invalid glob pattern: %w", err)
 		}
 		patterns = append(patterns, g)
 	}
 
+	if conf.Git.Enabled {
+		if _, err := exec.LookPath("git"); err != nil {
+			return nil, fmt.Errorf("git is enabled, but not installed: %w", err)
+		}
+	}
+
 	fileChanges := make(chan notify.EventInfo, 1)
 	watchPath := path.Join(root, "/...")
 	if err := notify.Watch(watchPath, fileChanges, notify.All); err != nil {
-		return nil, fmt.Errorf("error starting file watcher", err)
+		return nil, fmt.Errorf("error starting file watcher: %w", err)
 	}
 
 	return &SyncHandler{
@@ -49,16 +70,94 @@ func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseCo
 		controlCh:      make(chan bool, 1),
 		excludePatters: patterns,
 		fileChanges:    fileChanges,
+		gitPending:     map[string]string{},
+		gitChanged:     make(chan struct{}, 1),
 	}, nil
 }
 
 func (h *SyncHandler) SyncCmd() *cobra.Command {
 	return &cobra.Command{
 		Use:   "sync",
-		Short: "Scan notes directory and load markdown files into the files table",
-		Run: func(cmd *cobra.Command, args []string) {
-			h.InitialSync()
-			go h.WatcherManager()
+		Short: "Sync new, changed and removed markdown files into the files table and exit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+			startTime := time.Now()
+			summary, err := h.Sync()
+			if err != nil {
+				return err
+			}
+			h.CommitPending()
+			cmd.Printf(
+				"added %d, updated %d, deleted %d, unchanged %d, failed %d in %s\n",
+				summary.Added, summary.Updated, summary.Deleted, summary.Unchanged, summary.Failed,
+				time.Since(startTime).Round(time.Millisecond),
+			)
+			return nil
+		},
+	}
+}
+
+func (h *SyncHandler) WatchCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "watch",
+		Short: "Sync markdown files and keep watching them for changes without the HTTP server",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+			summary, err := h.Sync()
+			if err != nil {
+				return err
+			}
+			cmd.Printf("added %d, updated %d, deleted %d, watching %s\n", summary.Added, summary.Updated, summary.Deleted, h.root)
+
+			go h.GitManager()
+			done := make(chan struct{})
+			go func() {
+				h.WatcherManager()
+				close(done)
+			}()
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			<-signals
+			close(h.controlCh)
+			<-done
+			h.CommitPending()
+			return nil
+		},
+	}
+}
+
+func (h *SyncHandler) VerifyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:          "verify",
+		SilenceUsage: true,
+		Short:        "Report the drift between markdown files and the files table, exits with 1 on drift",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			drift, err := h.Verify()
+			if err != nil {
+				return err
+			}
+			for _, path := range drift.Missing {
+				cmd.Printf("+ %s (not in the database)\n", path)
+			}
+			for _, path := range drift.Orphaned {
+				cmd.Printf("- %s (not on disk)\n", path)
+			}
+			for _, path := range drift.Modified {
+				cmd.Printf("M %s\n", path)
+			}
+			if !drift.Empty() {
+				return fmt.Errorf(
+					"drift detected: %d missing, %d orphaned, %d modified",
+					len(drift.Missing), len(drift.Orphaned), len(drift.Modified),
+				)
+			}
+			cmd.Println("no drift")
+			return nil
 		},
 	}
 }
@@ -80,4 +179,33 @@ func (h *SyncHandler) Routes(se *core.ServeEvent) {
 		h.controlCh <- true
 		return nil
 	})
+
+	gitGroup := se.Router.Group("/git")
+	gitGroup.Bind(apis.RequireSuperuserAuth())
+	gitGroup.GET("/log", func(e *core.RequestEvent) error {
+		if !h.conf.Git.Enabled {
+			return apis.NewNotFoundError("git integration is disabled", nil)
+		}
+		query := e.Request.URL.Query()
+		path := query.Get("path")
+		if fileId := query.Get("file"); fileId != "" {
+			record, err := h.app.FindRecordById("files", fileId)
+			if err != nil {
+				return apis.NewNotFoundError("file not found", nil)
+			}
+			path = record.GetString("path")
+		}
+		if path == "" {
+			return apis.NewBadRequestError("file or path is required", nil)
+		}
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		if limit <= 0 {
+			limit = 50
+		}
+		commits, err := h.GitLog(path, limit)
+		if err != nil {
+			return apis.NewBadRequestError("unable to read git log", err)
+		}
+		return e.JSON(http.StatusOK, commits)
+	})
 }
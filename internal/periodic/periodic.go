@@ -0,0 +1,296 @@
+package periodic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/moment"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+type PeriodicHandler struct {
+	app       *pocketbase.PocketBase
+	root      string
+	conf      *config.NotebaseConfig
+	loc       *time.Location
+	templates *templates.TemplatesHandler
+	notes     templates.NoteCreator
+}
+
+// Note is a periodic note, which may not exist yet. Previous and Next point
+// to the neighbouring periods.
+type Note struct {
+	Period   string       `json:"period"`
+	Date     string       `json:"date"`
+	End      string       `json:"end"`
+	Path     string       `json:"path"`
+	Exists   bool         `json:"exists"`
+	Created  bool         `json:"created,omitempty"`
+	Record   *core.Record `json:"record,omitempty"`
+	Previous *Note        `json:"previous,omitempty"`
+	Next     *Note        `json:"next,omitempty"`
+}
+
+var ErrDisabled = errors.New("period is not enabled")
+
+var literalRe = regexp.MustCompile(`\[[^\]]*\]`)
+
+func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseConfig, templatesHandler *templates.TemplatesHandler, notes templates.NoteCreator) (*PeriodicHandler, error) {
+	return &PeriodicHandler{
+		app:       app,
+		root:      root,
+		conf:      conf,
+		loc:       conf.Location(),
+		templates: templatesHandler,
+		notes:     notes,
+	}, nil
+}
+
+func (h *PeriodicHandler) period(name string) (config.PeriodConfig, error) {
+	var period config.PeriodConfig
+	switch name {
+	case Daily:
+		period = h.conf.Periodic.Daily
+	case Weekly:
+		period = h.conf.Periodic.Weekly
+	case Monthly:
+		period = h.conf.Periodic.Monthly
+	default:
+		return period, fmt.Errorf("unknown period %q", name)
+	}
+	if !period.Enabled {
+		return period, ErrDisabled
+	}
+	return period, nil
+}
+
+// Start returns the beginning of the period, which contains t. Weeks start
+// on Monday, when the format uses ISO weeks, and on Sunday otherwise, the way
+// Moment.js does it.
+func (h *PeriodicHandler) Start(name string, t time.Time) time.Time {
+	t = t.In(h.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, h.loc)
+	switch name {
+	case Weekly:
+		weekday := int(day.Weekday())
+		if isoWeeks(h.conf.Periodic.Weekly.Format) {
+			weekday = (weekday + 6) % 7
+		}
+		return day.AddDate(0, 0, -weekday)
+	case Monthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, h.loc)
+	}
+	return day
+}
+
+// Add moves the start of a period by n periods.
+func (h *PeriodicHandler) Add(name string, start time.Time, n int) time.Time {
+	switch name {
+	case Weekly:
+		return start.AddDate(0, 0, 7*n)
+	case Monthly:
+		return start.AddDate(0, n, 0)
+	}
+	return start.AddDate(0, 0, n)
+}
+
+func isoWeeks(format string) bool {
+	format = literalRe.ReplaceAllString(format, "")
+	return strings.Contains(format, "G") || strings.Contains(format, "W")
+}
+
+// Path returns the path of the note of the period, which starts at start.
+func (h *PeriodicHandler) Path(period config.PeriodConfig, start time.Time) string {
+	return filepath.Join(period.Folder, moment.Format(start, period.Format)+".md")
+}
+
+// Date returns the start of the period of a note path, which is the reverse
+// of Path. Formats with week numbers can not be parsed back.
+func (h *PeriodicHandler) Date(name, path string) (time.Time, bool) {
+	var period config.PeriodConfig
+	switch name {
+	case Daily:
+		period = h.conf.Periodic.Daily
+	case Monthly:
+		period = h.conf.Periodic.Monthly
+	default:
+		return time.Time{}, false
+	}
+	if !utils.Within(period.Folder, path) {
+		return time.Time{}, false
+	}
+	rel, _ := filepath.Rel(period.Folder, path)
+	t, err := moment.Parse(period.Format, strings.TrimSuffix(rel, ".md"), h.loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// Location is the timezone, which the periods are computed in.
+func (h *PeriodicHandler) Location() *time.Location {
+	return h.loc
+}
+
+func (h *PeriodicHandler) note(name string, period config.PeriodConfig, start time.Time) *Note {
+	note := &Note{
+		Period: name,
+		Date:   start.Format(time.DateOnly),
+		End:    h.Add(name, start, 1).AddDate(0, 0, -1).Format(time.DateOnly),
+		Path:   h.Path(period, start),
+	}
+	if _, err := os.Stat(filepath.Join(h.root, note.Path)); err == nil {
+		note.Exists = true
+	}
+	return note
+}
+
+// Open returns the note of the period, which contains date, and creates it
+// when create is set.
+func (h *PeriodicHandler) Open(name string, date time.Time, create bool) (*Note, error) {
+	period, err := h.period(name)
+	if err != nil {
+		return nil, err
+	}
+	start := h.Start(name, date)
+	note := h.note(name, period, start)
+	note.Previous = h.note(name, period, h.Add(name, start, -1))
+	note.Next = h.note(name, period, h.Add(name, start, 1))
+
+	if !note.Exists && create {
+		_, err := h.create(period, note.Path, start)
+		// another request may have created the note in the meantime
+		if _, statErr := os.Stat(filepath.Join(h.root, note.Path)); statErr != nil {
+			return nil, err
+		}
+		note.Exists = true
+		note.Created = err == nil
+	}
+	if note.Exists {
+		record, err := h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": note.Path})
+		if err == nil {
+			note.Record = record
+		}
+	}
+	return note, nil
+}
+
+func (h *PeriodicHandler) create(period config.PeriodConfig, path string, start time.Time) (*core.Record, error) {
+	if period.Template == "" {
+		return h.notes.CreateNote(path, "", "")
+	}
+	return h.templates.Create(templates.RenderRequest{
+		Template: period.Template,
+		Path:     path,
+		Now:      start,
+	})
+}
+
+// ScheduleCreation creates the notes of the current periods on startup and
+// then every midnight in the configured timezone. The PocketBase cron has a
+// single timezone for all jobs, so the job runs every minute and only
+// creates the notes, when the day has changed in ours.
+func (h *PeriodicHandler) ScheduleCreation() {
+	if !h.conf.Periodic.CreateAtMidnight {
+		return
+	}
+	var (
+		mu  sync.Mutex
+		day = time.Now().In(h.loc).Format(time.DateOnly)
+	)
+	h.CreateCurrent()
+	err := h.app.Cron().Add("periodic_creation", "* * * * *", func() {
+		mu.Lock()
+		defer mu.Unlock()
+		today := time.Now().In(h.loc).Format(time.DateOnly)
+		if today == day {
+			return
+		}
+		day = today
+		h.CreateCurrent()
+	})
+	if err != nil {
+		h.app.Logger().Error("unable to schedule periodic notes creation", "error", err)
+	}
+}
+
+// CreateCurrent creates the missing notes of the enabled periods.
+func (h *PeriodicHandler) CreateCurrent() {
+	now := time.Now()
+	for _, name := range []string{Daily, Weekly, Monthly} {
+		if _, err := h.period(name); err != nil {
+			continue
+		}
+		note, err := h.Open(name, now, true)
+		if err != nil {
+			h.app.Logger().Error("error creating periodic note", "period", name, "error", err)
+			continue
+		}
+		if note.Created {
+			h.app.Logger().Info("created periodic note", "period", name, "path", note.Path)
+		}
+	}
+}
+
+func (h *PeriodicHandler) Routes(se *core.ServeEvent) {
+	periodicGroup := se.Router.Group("/periodic")
+	periodicGroup.Bind(apis.RequireSuperuserAuth())
+	// GET only looks the note up, POST creates it when it is missing
+	periodicGroup.GET("/{period}", func(e *core.RequestEvent) error {
+		return h.handleOpen(e, false)
+	})
+	periodicGroup.POST("/{period}", func(e *core.RequestEvent) error {
+		return h.handleOpen(e, true)
+	})
+}
+
+func (h *PeriodicHandler) handleOpen(e *core.RequestEvent, create bool) error {
+	query := e.Request.URL.Query()
+	date := time.Now()
+	if value := query.Get("date"); value != "" {
+		var ok bool
+		date, ok = utils.ParseDate(value, h.loc)
+		if !ok {
+			return apis.NewBadRequestError("invalid date", nil)
+		}
+	}
+	name := e.Request.PathValue("period")
+	if value := query.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil {
+			return apis.NewBadRequestError("invalid offset", nil)
+		}
+		date = h.Add(name, h.Start(name, date), offset)
+	}
+	note, err := h.Open(name, date, create)
+	if errors.Is(err, ErrDisabled) {
+		return apis.NewNotFoundError(err.Error(), nil)
+	}
+	if err != nil {
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+	if note.Created {
+		return e.JSON(http.StatusCreated, note)
+	}
+	return e.JSON(http.StatusOK, note)
+}
@@ -0,0 +1,175 @@
+package properties
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeDate   = "date"
+	TypeObject = "object"
+	TypeNull   = "null"
+)
+
+// Property is a single flattened frontmatter value. Lists are stored as
+// one property per element with Idx being the position in the list,
+// nested objects are stored as JSON in Text. Empty strings are nulls.
+type Property struct {
+	Key    string
+	Idx    int
+	List   bool
+	Type   string
+	Text   string
+	Number float64
+	Date   string
+	Bool   bool
+}
+
+type PropertiesHandler struct {
+	app  *pocketbase.PocketBase
+	conf *config.NotebaseConfig
+}
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) *PropertiesHandler {
+	return &PropertiesHandler{
+		app:  app,
+		conf: conf,
+	}
+}
+
+// Changed reports whether the properties of the record have to be rebuilt.
+func Changed(record *core.Record) bool {
+	original := record.Original()
+	return record.GetString("frontmatter") != original.GetString("frontmatter") ||
+		record.GetString("deleted") != original.GetString("deleted")
+}
+
+// Replace rebuilds the properties of a single file. It expects the app of the
+// record event, so that the rows are written in the same transaction as the file.
+func (h *PropertiesHandler) Replace(app core.App, record *core.Record) error {
+	db := app.NonconcurrentDB()
+	_, err := db.Delete("properties", dbx.HashExp{"file": record.Id}).Execute()
+	if err != nil {
+		return err
+	}
+	if record.GetString("deleted") != "" {
+		return nil
+	}
+
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		// invalid frontmatter is reported by the schema validation
+		return nil
+	}
+	for _, property := range Flatten(fm.Map()) {
+		_, err := db.Insert("properties", dbx.Params{
+			"id":     core.GenerateDefaultRandomId(),
+			"file":   record.Id,
+			"key":    property.Key,
+			"idx":    property.Idx,
+			"list":   property.List,
+			"type":   property.Type,
+			"text":   property.Text,
+			"number": property.Number,
+			"date":   property.Date,
+			"bool":   property.Bool,
+		}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rebuild fills the properties table from scratch, it is used when the table
+// is empty, but the files are already synced (e.g. right after the migration).
+func (h *PropertiesHandler) Rebuild() {
+	var count int
+	if err := h.app.DB().NewQuery("SELECT COUNT(*) FROM properties").Row(&count); err != nil || count > 0 {
+		return
+	}
+
+	startTime := time.Now()
+	records, err := h.app.FindRecordsByFilter("files", "deleted = ''", "", 0, 0)
+	if err != nil {
+		h.app.Logger().Error("unable to load files for properties", "error", err)
+		return
+	}
+	err = h.app.RunInTransaction(func(txApp core.App) error {
+		for _, record := range records {
+			if err := h.Replace(txApp, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.app.Logger().Error("unable to rebuild properties", "error", err)
+		return
+	}
+	h.app.Logger().Info("Properties rebuilt", "files", len(records), "elapsed", time.Since(startTime).String())
+}
+
+func Flatten(fm map[string]any) []Property {
+	result := make([]Property, 0, len(fm))
+	for key, value := range fm {
+		if list, ok := value.([]any); ok {
+			if len(list) == 0 {
+				result = append(result, Property{Key: key, List: true, Type: TypeNull})
+			}
+			for i, item := range list {
+				property := newProperty(key, i, item)
+				property.List = true
+				result = append(result, property)
+			}
+			continue
+		}
+		result = append(result, newProperty(key, 0, value))
+	}
+	return result
+}
+
+func newProperty(key string, idx int, value any) Property {
+	property := Property{Key: key, Idx: idx}
+	switch v := value.(type) {
+	case nil:
+		property.Type = TypeNull
+	case bool:
+		property.Type = TypeBool
+		property.Bool = v
+		property.Text = strconv.FormatBool(v)
+	case float64:
+		property.Type = TypeNumber
+		property.Number = v
+		property.Text = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		if v == "" {
+			property.Type = TypeNull
+			break
+		}
+		property.Type = TypeString
+		property.Text = v
+		if t, ok := utils.ParseDate(v, time.UTC); ok {
+			property.Type = TypeDate
+			date, _ := types.ParseDateTime(t)
+			property.Date = date.String()
+		}
+	default:
+		property.Type = TypeObject
+		b, _ := json.Marshal(v)
+		property.Text = string(b)
+	}
+	return property
+}
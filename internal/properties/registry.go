@@ -0,0 +1,154 @@
+package properties
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// The registry is aggregated from the properties table on request. The table
+// itself is kept up to date on every file save, so there is nothing else to
+// maintain incrementally.
+
+type Key struct {
+	Key          string         `json:"key"`
+	Notes        int            `json:"notes"`
+	Types        map[string]int `json:"types"`
+	Lists        int            `json:"lists"`
+	Inconsistent bool           `json:"inconsistent"`
+}
+
+type Value struct {
+	Value string `db:"value" json:"value"`
+	Type  string `db:"type" json:"type"`
+	Notes int    `db:"notes" json:"notes"`
+}
+
+func (h *PropertiesHandler) Routes(se *core.ServeEvent) {
+	propertiesGroup := se.Router.Group("/properties")
+	propertiesGroup.Bind(apis.RequireAuth())
+	propertiesGroup.GET("", func(e *core.RequestEvent) error {
+		keys, err := h.Keys(e.Request.URL.Query().Get("note_type"))
+		if err != nil {
+			return apis.NewBadRequestError("unable to list properties", err)
+		}
+		return e.JSON(http.StatusOK, keys)
+	})
+	propertiesGroup.GET("/{key}/values", func(e *core.RequestEvent) error {
+		query := e.Request.URL.Query()
+		limit, _ := strconv.Atoi(query.Get("limit"))
+		values, err := h.Values(e.Request.PathValue("key"), query.Get("q"), query.Get("note_type"), limit)
+		if err != nil {
+			return apis.NewBadRequestError("unable to list property values", err)
+		}
+		return e.JSON(http.StatusOK, values)
+	})
+}
+
+// Keys returns every frontmatter key with the number of notes using it and
+// the types of its values. A key is inconsistent when its values have more
+// than one type (nulls don't count) or it is a list only in some of the notes.
+// noteType limits the registry to notes with a given `type`.
+func (h *PropertiesHandler) Keys(noteType string) ([]Key, error) {
+	totals := []struct {
+		Key     string `db:"key"`
+		Notes   int    `db:"notes"`
+		Lists   int    `db:"lists"`
+		Scalars int    `db:"scalars"`
+	}{}
+	q := h.app.DB().Select(
+		"p.key",
+		"COUNT(DISTINCT p.file) AS notes",
+		"COUNT(DISTINCT CASE WHEN p.list THEN p.file END) AS lists",
+		"COUNT(DISTINCT CASE WHEN p.list OR p.type = 'null' THEN NULL ELSE p.file END) AS scalars",
+	).
+		From("properties p").
+		GroupBy("p.key")
+	if noteType != "" {
+		q.InnerJoin("properties t", noteTypeExp(noteType))
+	}
+	if err := q.All(&totals); err != nil {
+		return nil, err
+	}
+
+	rows := []struct {
+		Key   string `db:"key"`
+		Type  string `db:"type"`
+		Notes int    `db:"notes"`
+	}{}
+	q = h.app.DB().Select("p.key", "p.type", "COUNT(DISTINCT p.file) AS notes").
+		From("properties p").
+		GroupBy("p.key", "p.type")
+	if noteType != "" {
+		q.InnerJoin("properties t", noteTypeExp(noteType))
+	}
+	if err := q.All(&rows); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*Key, len(totals))
+	for _, total := range totals {
+		keys[total.Key] = &Key{
+			Key:          total.Key,
+			Notes:        total.Notes,
+			Lists:        total.Lists,
+			Types:        map[string]int{},
+			Inconsistent: total.Lists > 0 && total.Scalars > 0,
+		}
+	}
+	for _, row := range rows {
+		if key := keys[row.Key]; key != nil {
+			key.Types[row.Type] = row.Notes
+		}
+	}
+
+	result := make([]Key, 0, len(keys))
+	for _, key := range keys {
+		valueTypes := 0
+		for t := range key.Types {
+			if t != TypeNull {
+				valueTypes++
+			}
+		}
+		key.Inconsistent = key.Inconsistent || valueTypes > 1
+		result = append(result, *key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+// Values returns distinct values of a key ordered by usage, prefix narrows
+// them down for autocomplete. Nulls and nested objects are skipped.
+func (h *PropertiesHandler) Values(key, prefix, noteType string, limit int) ([]Value, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	values := []Value{}
+	q := h.app.DB().Select("p.text AS value", "p.type AS type", "COUNT(DISTINCT p.file) AS notes").
+		From("properties p").
+		Where(dbx.HashExp{"p.key": key}).
+		AndWhere(dbx.NotIn("p.type", TypeNull, TypeObject)).
+		GroupBy("p.text", "p.type").
+		OrderBy("notes DESC", "value ASC").
+		Limit(int64(limit))
+	if prefix != "" {
+		q.AndWhere(dbx.Like("p.text", prefix).Match(false, true))
+	}
+	if noteType != "" {
+		q.InnerJoin("properties t", noteTypeExp(noteType))
+	}
+	if err := q.All(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func noteTypeExp(noteType string) dbx.Expression {
+	return dbx.NewExp("t.file = p.file AND t.key = 'type' AND t.text = {:noteType}", dbx.Params{"noteType": noteType})
+}
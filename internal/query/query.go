@@ -0,0 +1,265 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Statements are checked for the leading keyword only, the actual protection
+// comes from the read-only connection (mode=ro + query_only).
+var readOnlyStatement = regexp.MustCompile(`(?is)^\s*(select|with|explain)\b`)
+
+type QueryHandler struct {
+	app  *pocketbase.PocketBase
+	conf *config.NotebaseConfig
+
+	dbOnce sync.Once
+	db     *sql.DB
+	dbErr  error
+}
+
+type Request struct {
+	SQL    string `json:"sql"`
+	Format string `json:"format"`
+	Limit  int    `json:"limit"`
+}
+
+type Result struct {
+	Columns   []string         `json:"columns"`
+	Rows      []map[string]any `json:"rows"`
+	Truncated bool             `json:"truncated"`
+}
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) *QueryHandler {
+	return &QueryHandler{
+		app:  app,
+		conf: conf,
+	}
+}
+
+func (h *QueryHandler) Routes(se *core.ServeEvent) {
+	queryGroup := se.Router.Group("/query")
+	queryGroup.Bind(apis.RequireSuperuserAuth())
+	queryGroup.GET("", func(e *core.RequestEvent) error {
+		limit, _ := strconv.Atoi(e.Request.URL.Query().Get("limit"))
+		return h.respond(e, Request{
+			SQL:    e.Request.URL.Query().Get("sql"),
+			Format: e.Request.URL.Query().Get("format"),
+			Limit:  limit,
+		})
+	})
+	queryGroup.POST("", func(e *core.RequestEvent) error {
+		req := Request{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request body", err)
+		}
+		if req.Format == "" {
+			req.Format = e.Request.URL.Query().Get("format")
+		}
+		return h.respond(e, req)
+	})
+}
+
+func (h *QueryHandler) respond(e *core.RequestEvent, req Request) error {
+	result, err := h.Run(e.Request.Context(), req.SQL, req.Limit)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return apis.NewApiError(http.StatusRequestTimeout, "query timed out", nil)
+		}
+		return apis.NewBadRequestError(err.Error(), nil)
+	}
+
+	switch req.Format {
+	case "", "json":
+		return e.JSON(http.StatusOK, result)
+	case "csv":
+		e.Response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		e.Response.WriteHeader(http.StatusOK)
+		return writeCSV(e.Response, result)
+	default:
+		return apis.NewBadRequestError(fmt.Sprintf("unknown format %q", req.Format), nil)
+	}
+}
+
+// Run executes a single read-only statement, stopping after limit rows.
+// The limit is capped by the configured max_rows.
+func (h *QueryHandler) Run(ctx context.Context, statement string, limit int) (Result, error) {
+	result := Result{Columns: []string{}, Rows: []map[string]any{}}
+
+	statement = strings.TrimSpace(statement)
+	statement = strings.TrimSuffix(statement, ";")
+	if statement == "" {
+		return result, errors.New("sql is required")
+	}
+	if !readOnlyStatement.MatchString(skipLeadingComments(statement)) {
+		return result, errors.New("only SELECT statements are allowed")
+	}
+	if hasMultipleStatements(statement) {
+		return result, errors.New("only a single statement is allowed")
+	}
+
+	if limit <= 0 || limit > h.conf.Query.MaxRows {
+		limit = h.conf.Query.MaxRows
+	}
+
+	db, err := h.readOnlyDB()
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.conf.Query.Timeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, statement)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return result, err
+	}
+	result.Columns = columns
+
+	for rows.Next() {
+		if len(result.Rows) >= limit {
+			result.Truncated = true
+			break
+		}
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return result, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+				continue
+			}
+			row[column] = values[i]
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	return result, nil
+}
+
+// readOnlyDB lazily opens a separate connection pool to the PocketBase data
+// database, so that even a crafted statement can't modify anything.
+func (h *QueryHandler) readOnlyDB() (*sql.DB, error) {
+	h.dbOnce.Do(func() {
+		dbPath := filepath.Join(h.app.DataDir(), "data.db")
+		dsn := "file:" + dbPath + "?mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(10000)"
+		h.db, h.dbErr = sql.Open("sqlite", dsn)
+	})
+	return h.db, h.dbErr
+}
+
+// skipLeadingComments drops the whitespace and comments in front of the
+// leading keyword of a statement.
+func skipLeadingComments(statement string) string {
+	for {
+		statement = strings.TrimLeftFunc(statement, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(statement, "--"):
+			end := strings.IndexByte(statement, '\n')
+			if end < 0 {
+				return ""
+			}
+			statement = statement[end:]
+		case strings.HasPrefix(statement, "/*"):
+			end := strings.Index(statement[2:], "*/")
+			if end < 0 {
+				return ""
+			}
+			statement = statement[end+4:]
+		default:
+			return statement
+		}
+	}
+}
+
+// hasMultipleStatements reports whether there is anything besides whitespace
+// and comments after a semicolon, that is outside of string literals.
+func hasMultipleStatements(statement string) bool {
+	ended := false
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			if ended {
+				return true
+			}
+			if end := strings.IndexByte(statement[i+1:], c); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(statement)
+			}
+		case strings.HasPrefix(statement[i:], "--"):
+			if end := strings.IndexByte(statement[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(statement)
+			}
+		case strings.HasPrefix(statement[i:], "/*"):
+			if end := strings.Index(statement[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(statement)
+			}
+		case c == ';':
+			ended = true
+		case ended && !unicode.IsSpace(rune(c)):
+			return true
+		}
+	}
+	return false
+}
+
+func writeCSV(w http.ResponseWriter, result Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(result.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(result.Columns))
+	for _, row := range result.Rows {
+		for i, column := range result.Columns {
+			if row[column] == nil {
+				record[i] = ""
+				continue
+			}
+			record[i] = fmt.Sprint(row[column])
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
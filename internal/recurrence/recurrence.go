@@ -0,0 +1,194 @@
+package recurrence
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Frontmatter keys of recurring notes. `completed` is what the web UI sets,
+// when a note is toggled.
+const (
+	repeatKey      = "repeat"
+	dueKey         = "due"
+	completedKey   = "completed"
+	completionsKey = "completions"
+)
+
+type RecurrenceHandler struct {
+	app *pocketbase.PocketBase
+	loc *time.Location
+}
+
+// Obsidian Tasks markers, see https://publish.obsidian.md/tasks/Reference/Task+Formats/Tasks+Emoji+Format
+var (
+	taskRe       = regexp.MustCompile(`^(\s*[-*+] \[)(.)(\] )(.*)$`)
+	taskDateRe   = regexp.MustCompile(`(📅|⏳|🛫|✅) ?(\d{4}-\d{2}-\d{2})`)
+	doneRe       = regexp.MustCompile(` ?✅ ?\d{4}-\d{2}-\d{2}`)
+	taskRepeatRe = regexp.MustCompile(`🔁 ?([^📅⏳🛫✅➕❌🔺⏫🔼🔽⏬🆔⛔]+)`)
+)
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) *RecurrenceHandler {
+	return &RecurrenceHandler{app: app, loc: conf.Location()}
+}
+
+// Apply regenerates the recurring tasks of a note, which have just been
+// completed. A note with a `repeat` rule is rolled forward: its due date is
+// moved to the next occurrence, `completed` is cleared and the completion is
+// kept in `completions`. A completed checkbox task with 🔁 gets the next
+// occurrence inserted above it, the way the Obsidian Tasks plugin does it.
+func (h *RecurrenceHandler) Apply(record *core.Record) {
+	if record.IsNew() || record.GetString("deleted") != "" {
+		return
+	}
+	h.rollForward(record)
+	h.spawnTasks(record)
+}
+
+func (h *RecurrenceHandler) rollForward(record *core.Record) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return
+	}
+	repeat := fm.GetString(repeatKey)
+	completed := fm.GetString(completedKey)
+	if repeat == "" || completed == "" {
+		return
+	}
+	original, err := frontmatter.Parse(record.Original().GetString("frontmatter"))
+	if err != nil || original.GetString(completedKey) != "" {
+		return
+	}
+	rule, err := Parse(repeat)
+	if err != nil {
+		h.app.Logger().Warn("invalid repeat rule", "path", record.GetString("path"), "error", err)
+		return
+	}
+
+	done, ok := utils.ParseDate(completed, h.loc)
+	if !ok {
+		done = time.Now().In(h.loc)
+	}
+	base, layout := done, time.DateOnly
+	if due, dueLayout, ok := utils.ParseDateLayout(fm.GetString(dueKey), h.loc); ok && !rule.WhenDone {
+		base, layout = due, dueLayout
+	}
+
+	completions, _ := fm.Get(completionsKey)
+	history, _ := completions.([]any)
+	fm.Set(completionsKey, append(history, completed))
+	fm.Set(dueKey, rule.Next(base).Format(layout))
+	fm.Set(completedKey, "")
+	frontmatterJSON, err := fm.JSON()
+	if err != nil {
+		return
+	}
+	record.Set("frontmatter", frontmatterJSON)
+}
+
+func (h *RecurrenceHandler) spawnTasks(record *core.Record) {
+	content := record.GetString("content")
+	if !strings.Contains(content, "🔁") {
+		return
+	}
+	// recurring tasks, which were open before this change
+	open := map[string]bool{}
+	for _, line := range strings.Split(record.Original().GetString("content"), "\n") {
+		if m := taskRe.FindStringSubmatch(line); m != nil && m[2] == " " && strings.Contains(m[4], "🔁") {
+			open[taskKey(m[4])] = true
+		}
+	}
+
+	lines := strings.Split(content, "\n")
+	result := make([]string, 0, len(lines)+1)
+	changed := false
+	for _, line := range lines {
+		m := taskRe.FindStringSubmatch(line)
+		if m == nil || (m[2] != "x" && m[2] != "X") || !open[taskKey(m[4])] {
+			result = append(result, line)
+			continue
+		}
+		next, completedLine, ok := h.nextTask(m)
+		if !ok || containsLine(lines, next) {
+			result = append(result, line)
+			continue
+		}
+		result = append(result, next, completedLine)
+		changed = true
+	}
+	if changed {
+		record.Set("content", strings.Join(result, "\n"))
+	}
+}
+
+// nextTask returns the next occurrence of a completed task and the completed
+// task with the done date.
+func (h *RecurrenceHandler) nextTask(m []string) (string, string, bool) {
+	description := m[4]
+	repeat := taskRepeatRe.FindStringSubmatch(description)
+	if repeat == nil {
+		return "", "", false
+	}
+	rule, err := Parse(strings.TrimSpace(repeat[1]))
+	if err != nil {
+		h.app.Logger().Warn("invalid task repeat rule", "rule", repeat[1], "error", err)
+		return "", "", false
+	}
+
+	dates := map[string]time.Time{}
+	for _, d := range taskDateRe.FindAllStringSubmatch(description, -1) {
+		if t, err := time.ParseInLocation(time.DateOnly, d[2], h.loc); err == nil {
+			dates[d[1]] = t
+		}
+	}
+	completedLine := m[0]
+	done, ok := dates["✅"]
+	if !ok {
+		now := time.Now().In(h.loc)
+		done = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, h.loc)
+		completedLine = strings.TrimRight(m[0], " ") + " ✅ " + done.Format(time.DateOnly)
+	}
+	// the next occurrence is computed from the first of the due, scheduled and
+	// start dates and the other dates are moved by the same number of days,
+	// like Tasks does
+	ref, hasRef := done, false
+	for _, marker := range []string{"📅", "⏳", "🛫"} {
+		if t, ok := dates[marker]; ok {
+			ref, hasRef = t, true
+			break
+		}
+	}
+	target := rule.Next(ref)
+	if rule.WhenDone {
+		target = rule.Next(done)
+	}
+	days := int(target.Sub(ref).Round(24*time.Hour).Hours() / 24)
+	next := doneRe.ReplaceAllString(description, "")
+	if hasRef {
+		next = taskDateRe.ReplaceAllStringFunc(next, func(s string) string {
+			d := taskDateRe.FindStringSubmatch(s)
+			return strings.Replace(s, d[2], dates[d[1]].AddDate(0, 0, days).Format(time.DateOnly), 1)
+		})
+	}
+	return m[1] + " " + m[3] + next, completedLine, true
+}
+
+// taskKey identifies a task regardless of its status and done date.
+func taskKey(description string) string {
+	return strings.TrimSpace(doneRe.ReplaceAllString(description, ""))
+}
+
+func containsLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == strings.TrimSpace(line) {
+			return true
+		}
+	}
+	return false
+}
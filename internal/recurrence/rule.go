@@ -0,0 +1,240 @@
+package recurrence
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Freq int
+
+const (
+	Daily Freq = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+// Rule is a recurrence rule in either the Obsidian Tasks syntax
+// (`every 2 weeks on Monday`) or the RRULE syntax (`FREQ=WEEKLY;INTERVAL=2`).
+type Rule struct {
+	Freq     Freq
+	Interval int
+	ByDay    []time.Weekday
+	// ByMonthDay is a list of days of the month, -1 is the last day.
+	ByMonthDay []int
+	// WhenDone repeats from the completion date instead of the due date.
+	WhenDone bool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "monday": time.Monday, "tuesday": time.Tuesday,
+	"wednesday": time.Wednesday, "thursday": time.Thursday,
+	"friday": time.Friday, "saturday": time.Saturday,
+	"su": time.Sunday, "mo": time.Monday, "tu": time.Tuesday, "we": time.Wednesday,
+	"th": time.Thursday, "fr": time.Friday, "sa": time.Saturday,
+}
+
+var units = map[string]Freq{
+	"day": Daily, "days": Daily,
+	"week": Weekly, "weeks": Weekly,
+	"month": Monthly, "months": Monthly,
+	"year": Yearly, "years": Yearly,
+}
+
+func Parse(value string) (Rule, error) {
+	value = strings.TrimSpace(value)
+	upper := strings.ToUpper(value)
+	if strings.HasPrefix(upper, "RRULE:") || strings.HasPrefix(upper, "FREQ=") {
+		return parseRRule(strings.TrimPrefix(upper, "RRULE:"))
+	}
+	return parseText(value)
+}
+
+func parseRRule(value string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	hasFreq := false
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch key {
+		case "FREQ":
+			hasFreq = true
+			switch val {
+			case "DAILY":
+				rule.Freq = Daily
+			case "WEEKLY":
+				rule.Freq = Weekly
+			case "MONTHLY":
+				rule.Freq = Monthly
+			case "YEARLY":
+				rule.Freq = Yearly
+			default:
+				return rule, fmt.Errorf("unsupported frequency %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("invalid interval %q", val)
+			}
+			rule.Interval = n
+		case "BYDAY":
+			for _, day := range strings.Split(val, ",") {
+				weekday, ok := weekdays[strings.ToLower(day)]
+				if !ok {
+					return rule, fmt.Errorf("unsupported day %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -1 || n > 31 {
+					return rule, fmt.Errorf("unsupported month day %q", day)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "WKST", "":
+		default:
+			return rule, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	if !hasFreq {
+		return rule, fmt.Errorf("FREQ is required")
+	}
+	return rule, nil
+}
+
+// parseText parses the Obsidian Tasks syntax: every [N] day|week|month|year,
+// every weekday, every Monday[, Friday], every week on Monday,
+// every month on the 15th|last, optionally followed by `when done`.
+func parseText(value string) (Rule, error) {
+	rule := Rule{Interval: 1}
+	text := strings.ToLower(value)
+	if rest, ok := strings.CutSuffix(text, " when done"); ok {
+		rule.WhenDone = true
+		text = rest
+	}
+	text, ok := strings.CutPrefix(text, "every ")
+	if !ok {
+		return rule, fmt.Errorf("invalid rule %q", value)
+	}
+	text, on, _ := strings.Cut(text, " on ")
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return rule, fmt.Errorf("invalid rule %q", value)
+	}
+	if n, err := strconv.Atoi(fields[0]); err == nil && len(fields) == 2 {
+		if n < 1 {
+			return rule, fmt.Errorf("invalid interval in %q", value)
+		}
+		rule.Interval = n
+		fields = fields[1:]
+	}
+	switch {
+	case len(fields) == 1 && fields[0] == "weekday":
+		rule.Freq = Weekly
+		rule.ByDay = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+		return rule, nil
+	case len(fields) == 1:
+		freq, ok := units[fields[0]]
+		if !ok {
+			// every Monday, Friday
+			on = text
+			freq = Weekly
+		}
+		rule.Freq = freq
+	default:
+		on = text
+		rule.Freq = Weekly
+	}
+	if on == "" {
+		return rule, nil
+	}
+	for _, part := range strings.FieldsFunc(on, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if part == "and" || part == "the" {
+			continue
+		}
+		if weekday, ok := weekdays[part]; ok && rule.Freq == Weekly {
+			rule.ByDay = append(rule.ByDay, weekday)
+			continue
+		}
+		if rule.Freq != Monthly {
+			return rule, fmt.Errorf("invalid rule %q", value)
+		}
+		if part == "last" {
+			rule.ByMonthDay = append(rule.ByMonthDay, -1)
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimRight(part, "stndrh"))
+		if err != nil || n < 1 || n > 31 {
+			return rule, fmt.Errorf("invalid rule %q", value)
+		}
+		rule.ByMonthDay = append(rule.ByMonthDay, n)
+	}
+	return rule, nil
+}
+
+// Next returns the first occurrence after t.
+func (r Rule) Next(t time.Time) time.Time {
+	switch {
+	case r.Freq == Weekly && len(r.ByDay) > 0:
+		// the rest of the current week, then the week after the interval
+		monday := t.AddDate(0, 0, -((int(t.Weekday()) + 6) % 7))
+		for week := 0; ; week += r.Interval {
+			for day := 0; day < 7; day++ {
+				candidate := monday.AddDate(0, 0, 7*week+day)
+				if candidate.After(t) && slices.Contains(r.ByDay, candidate.Weekday()) {
+					return candidate
+				}
+			}
+		}
+	case r.Freq == Monthly && len(r.ByMonthDay) > 0:
+		for month := 0; ; month += r.Interval {
+			candidates := []time.Time{}
+			for _, day := range r.ByMonthDay {
+				candidates = append(candidates, monthDay(t, month, day))
+			}
+			slices.SortFunc(candidates, func(a, b time.Time) int { return a.Compare(b) })
+			for _, candidate := range candidates {
+				if candidate.After(t) {
+					return candidate
+				}
+			}
+		}
+	case r.Freq == Daily && len(r.ByDay) > 0:
+		for candidate := t.AddDate(0, 0, r.Interval); ; candidate = candidate.AddDate(0, 0, 1) {
+			if slices.Contains(r.ByDay, candidate.Weekday()) {
+				return candidate
+			}
+		}
+	}
+	switch r.Freq {
+	case Weekly:
+		return t.AddDate(0, 0, 7*r.Interval)
+	case Monthly:
+		return addMonths(t, r.Interval)
+	case Yearly:
+		return addMonths(t, 12*r.Interval)
+	}
+	return t.AddDate(0, 0, r.Interval)
+}
+
+// addMonths clamps the day to the end of the month, so that January 31st
+// is followed by the last day of February.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+// monthDay returns the given day of the month, which is n months after t.
+func monthDay(t time.Time, n, day int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	if day == -1 || day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
@@ -0,0 +1,43 @@
+package recurrence
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		rule string
+		from string
+		want string
+	}{
+		{"FREQ=MONTHLY;BYMONTHDAY=31", "2026-01-31", "2026-02-28"},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", "2026-02-28", "2026-03-31"},
+		{"FREQ=MONTHLY;BYMONTHDAY=31", "2028-01-31", "2028-02-29"},
+		{"FREQ=MONTHLY;BYMONTHDAY=30", "2026-03-30", "2026-04-30"},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "2026-04-30", "2026-05-31"},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "2026-01-15", "2026-01-31"},
+		{"FREQ=MONTHLY;BYMONTHDAY=1,15", "2026-01-15", "2026-02-01"},
+		{"FREQ=MONTHLY;INTERVAL=2;BYMONTHDAY=31", "2025-12-31", "2026-02-28"},
+		{"FREQ=MONTHLY", "2026-01-31", "2026-02-28"},
+		{"FREQ=YEARLY", "2028-02-29", "2029-02-28"},
+		{"every month", "2026-03-31", "2026-04-30"},
+		{"every week on Monday", "2026-10-19", "2026-10-26"},
+		{"every weekday", "2026-10-16", "2026-10-19"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule+" "+tt.from, func(t *testing.T) {
+			rule, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := time.Parse(time.DateOnly, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rule.Next(from).Format(time.DateOnly); got != tt.want {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
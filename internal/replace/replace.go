@@ -0,0 +1,395 @@
+package replace
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	ScopeAll         = ""
+	ScopeContent     = "content"
+	ScopeFrontmatter = "frontmatter"
+)
+
+// maxMatches limits the size of a preview, a pattern matching every line
+// of the vault is most likely a mistake.
+const maxMatches = 1000
+
+var ErrConflict = errors.New("file was changed since the preview")
+
+type ReplaceHandler struct {
+	app  *pocketbase.PocketBase
+	root string
+	conf *config.NotebaseConfig
+}
+
+// Search is shared by the preview and the apply requests.
+type Search struct {
+	Find       string `json:"find"`
+	Replace    string `json:"replace"`
+	Regex      bool   `json:"regex"`
+	IgnoreCase bool   `json:"ignore_case"`
+	Scope      string `json:"scope"`
+
+	Folder string `json:"folder"`
+	Tag    string `json:"tag"`
+	Type   string `json:"type"`
+}
+
+// Selection is a file picked from the preview. Hash is the one returned by
+// the preview, Matches are the indexes of the matches to replace (all if empty).
+type Selection struct {
+	Id      string `json:"id"`
+	Hash    string `json:"hash"`
+	Matches []int  `json:"matches"`
+}
+
+type ApplyRequest struct {
+	Search
+	Files []Selection `json:"files"`
+}
+
+type Match struct {
+	Index       int    `json:"index"`
+	Scope       string `json:"scope"`
+	Line        int    `json:"line"`
+	Column      int    `json:"column"`
+	Text        string `json:"text"`
+	Replacement string `json:"replacement"`
+	// Context are the lines containing the match before and after the replacement.
+	Context string `json:"context"`
+	Preview string `json:"preview"`
+
+	start, end int
+}
+
+type FileMatches struct {
+	Id      string  `json:"id"`
+	Path    string  `json:"path"`
+	Hash    string  `json:"hash"`
+	Matches []Match `json:"matches"`
+}
+
+type Preview struct {
+	Files     []FileMatches `json:"files"`
+	Matches   int           `json:"matches"`
+	Truncated bool          `json:"truncated"`
+}
+
+type Result struct {
+	Files        int `json:"files"`
+	Replacements int `json:"replacements"`
+}
+
+func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseConfig) *ReplaceHandler {
+	return &ReplaceHandler{
+		app:  app,
+		root: root,
+		conf: conf,
+	}
+}
+
+func (h *ReplaceHandler) Routes(se *core.ServeEvent) {
+	replaceGroup := se.Router.Group("/replace")
+	replaceGroup.Bind(apis.RequireSuperuserAuth())
+	replaceGroup.POST("/preview", func(e *core.RequestEvent) error {
+		req := Search{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request body", err)
+		}
+		preview, err := h.Preview(req)
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, preview)
+	})
+	replaceGroup.POST("/apply", func(e *core.RequestEvent) error {
+		req := ApplyRequest{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request body", err)
+		}
+		result, err := h.Apply(req.Search, req.Files)
+		if errors.Is(err, ErrConflict) {
+			return apis.NewApiError(http.StatusConflict, err.Error(), nil)
+		}
+		if err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, result)
+	})
+}
+
+func (s Search) compile() (*regexp.Regexp, error) {
+	if s.Find == "" {
+		return nil, errors.New("find is required")
+	}
+	switch s.Scope {
+	case ScopeAll, ScopeContent, ScopeFrontmatter:
+	default:
+		return nil, fmt.Errorf("unknown scope %q, expected content or frontmatter", s.Scope)
+	}
+	pattern := s.Find
+	if !s.Regex {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if s.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re, nil
+}
+
+func (h *ReplaceHandler) findFiles(app core.App, s Search) ([]*core.Record, error) {
+	q := app.RecordQuery("files").
+		AndWhere(dbx.HashExp{"deleted": ""}).
+		OrderBy("path ASC")
+	if folder := strings.Trim(s.Folder, "/"); folder != "" {
+		q.AndWhere(dbx.Like("path", folder+"/").Match(false, true))
+	}
+	if s.Tag != "" {
+		q.AndWhere(dbx.Exists(dbx.NewExp(
+			"SELECT 1 FROM properties p WHERE p.file = files.id AND p.key = 'tags' AND p.text = {:tag}",
+			dbx.Params{"tag": strings.TrimPrefix(s.Tag, "#")},
+		)))
+	}
+	if s.Type != "" {
+		q.AndWhere(dbx.NewExp("json_extract(frontmatter, '$.type') = {:type}", dbx.Params{"type": s.Type}))
+	}
+	records := []*core.Record{}
+	if err := q.All(&records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Preview lists the matches of every file, the matches are numbered per file,
+// frontmatter first.
+func (h *ReplaceHandler) Preview(s Search) (Preview, error) {
+	preview := Preview{Files: []FileMatches{}}
+	re, err := s.compile()
+	if err != nil {
+		return preview, err
+	}
+	records, err := h.findFiles(h.app, s)
+	if err != nil {
+		return preview, err
+	}
+	for _, record := range records {
+		matches := s.matches(re, record)
+		if len(matches) == 0 {
+			continue
+		}
+		if preview.Matches+len(matches) > maxMatches {
+			preview.Truncated = true
+			break
+		}
+		preview.Matches += len(matches)
+		preview.Files = append(preview.Files, FileMatches{
+			Id:      record.Id,
+			Path:    record.GetString("path"),
+			Hash:    hash(record),
+			Matches: matches,
+		})
+	}
+	return preview, nil
+}
+
+func hash(record *core.Record) string {
+	return utils.GetDBHash(record.GetString("raw_frontmatter"), record.GetString("content"))
+}
+
+func (s Search) matches(re *regexp.Regexp, record *core.Record) []Match {
+	result := []Match{}
+	if s.Scope != ScopeContent {
+		result = append(result, s.find(re, ScopeFrontmatter, record.GetString("raw_frontmatter"), len(result))...)
+	}
+	if s.Scope != ScopeFrontmatter {
+		result = append(result, s.find(re, ScopeContent, record.GetString("content"), len(result))...)
+	}
+	return result
+}
+
+func (s Search) find(re *regexp.Regexp, scope, text string, offset int) []Match {
+	result := []Match{}
+	for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+		start, end := loc[0], loc[1]
+		if start == end {
+			// empty matches (e.g. `^`) don't replace anything meaningful
+			continue
+		}
+		replacement := s.replacement(re, text, loc)
+		lineStart := strings.LastIndex(text[:start], "\n") + 1
+		lineEnd := len(text)
+		if i := strings.Index(text[end:], "\n"); i >= 0 {
+			lineEnd = end + i
+		}
+		result = append(result, Match{
+			Index:       offset + len(result),
+			Scope:       scope,
+			Line:        strings.Count(text[:start], "\n") + 1,
+			Column:      len([]rune(text[lineStart:start])) + 1,
+			Text:        text[start:end],
+			Replacement: replacement,
+			Context:     text[lineStart:lineEnd],
+			Preview:     text[lineStart:start] + replacement + text[end:lineEnd],
+			start:       start,
+			end:         end,
+		})
+	}
+	return result
+}
+
+func (s Search) replacement(re *regexp.Regexp, text string, loc []int) string {
+	if !s.Regex {
+		return s.Replace
+	}
+	return string(re.ExpandString(nil, s.Replace, text, loc))
+}
+
+// replace applies the selected matches of a single scope.
+func replace(text string, matches []Match, selected func(int) bool) (string, int) {
+	var b strings.Builder
+	last, count := 0, 0
+	for _, m := range matches {
+		if !selected(m.Index) {
+			continue
+		}
+		b.WriteString(text[last:m.start])
+		b.WriteString(m.Replacement)
+		last = m.end
+		count++
+	}
+	b.WriteString(text[last:])
+	return b.String(), count
+}
+
+type pending struct {
+	path    string
+	content []byte
+	version string
+}
+
+// Apply replaces the selected matches in a single transaction. A file that
+// changed since the preview (in the database or on disk) aborts the whole
+// apply. The files are written inside the transaction and restored if any
+// of the writes fails, so that the vault and the database stay in sync.
+func (h *ReplaceHandler) Apply(s Search, selections []Selection) (Result, error) {
+	result := Result{}
+	re, err := s.compile()
+	if err != nil {
+		return result, err
+	}
+	if len(selections) == 0 {
+		return result, errors.New("no files selected")
+	}
+
+	err = h.app.RunInTransaction(func(txApp core.App) error {
+		writes := make([]pending, 0, len(selections))
+		for _, selection := range selections {
+			record, err := txApp.FindRecordById("files", selection.Id)
+			if err != nil || record.GetString("deleted") != "" {
+				return fmt.Errorf("file %s not found", selection.Id)
+			}
+			path := record.GetString("path")
+			absPath := filepath.Join(h.root, path)
+			current := hash(record)
+			if current != selection.Hash || utils.GetFSHash(absPath) != current {
+				return fmt.Errorf("%w: %s", ErrConflict, path)
+			}
+
+			selected := func(int) bool { return true }
+			if len(selection.Matches) > 0 {
+				indexes := make(map[int]bool, len(selection.Matches))
+				for _, i := range selection.Matches {
+					indexes[i] = true
+				}
+				selected = func(i int) bool { return indexes[i] }
+			}
+
+			matches := s.matches(re, record)
+			fmMatches := []Match{}
+			contentMatches := []Match{}
+			for _, m := range matches {
+				if m.Scope == ScopeFrontmatter {
+					fmMatches = append(fmMatches, m)
+				} else {
+					contentMatches = append(contentMatches, m)
+				}
+			}
+			rawFrontmatter, fmCount := replace(record.GetString("raw_frontmatter"), fmMatches, selected)
+			content, contentCount := replace(record.GetString("content"), contentMatches, selected)
+			if fmCount+contentCount == 0 {
+				continue
+			}
+			if fmCount > 0 {
+				if err := frontmatter.SetRaw(record, rawFrontmatter); err != nil {
+					return fmt.Errorf("%s: replacement breaks the frontmatter: %w", path, err)
+				}
+			}
+			record.Set("content", content)
+
+			version := utils.GetVersion()
+			record.Set("origin", "db")
+			record.Set("version", version)
+			if err := txApp.Save(record); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+
+			var b strings.Builder
+			if rawFrontmatter != "" {
+				b.WriteString("---\n")
+				b.WriteString(rawFrontmatter)
+				b.WriteString("---\n")
+			}
+			b.WriteString(content)
+			writes = append(writes, pending{path: absPath, content: []byte(b.String()), version: version})
+			result.Files++
+			result.Replacements += fmCount + contentCount
+		}
+		return h.write(writes)
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	return result, nil
+}
+
+// write saves all files or none of them.
+func (h *ReplaceHandler) write(writes []pending) error {
+	originals := make([][]byte, 0, len(writes))
+	for _, w := range writes {
+		original, err := os.ReadFile(w.path)
+		if err == nil {
+			originals = append(originals, original)
+			err = os.WriteFile(w.path, w.content, 0644)
+		}
+		if err != nil {
+			for j := range originals {
+				if restoreErr := os.WriteFile(writes[j].path, originals[j], 0644); restoreErr != nil {
+					h.app.Logger().Error("unable to restore file", "path", writes[j].path, "error", restoreErr)
+				}
+			}
+			return err
+		}
+	}
+	for _, w := range writes {
+		utils.SetFileXAttrs(w.path, utils.XAttrs{Version: w.version, Origin: "db"})
+	}
+	return nil
+}
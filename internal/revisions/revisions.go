@@ -0,0 +1,285 @@
+package revisions
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/textdiff"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// userKey is a custom (not persisted) record field with the user, who
+// changed the file through the API.
+const userKey = "revision_user"
+
+type RevisionsHandler struct {
+	app  *pocketbase.PocketBase
+	root string
+	conf *config.NotebaseConfig
+}
+
+// Revision is a full copy of a note, the way it is written to disk.
+// Revisions are bound to the path, so that the history survives
+// deleting and recreating the file.
+type Revision struct {
+	Id      string `db:"id" json:"id"`
+	File    string `db:"file" json:"file"`
+	Path    string `db:"path" json:"path"`
+	Hash    string `db:"hash" json:"hash"`
+	Origin  string `db:"origin" json:"origin"`
+	User    string `db:"user" json:"user"`
+	Size    int    `db:"size" json:"size"`
+	Created string `db:"created" json:"created"`
+	Data    string `db:"data" json:"-"`
+	Text    string `db:"-" json:"text,omitempty"`
+}
+
+type Diff struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Diff string `json:"diff"`
+}
+
+func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseConfig) *RevisionsHandler {
+	return &RevisionsHandler{
+		app:  app,
+		root: root,
+		conf: conf,
+	}
+}
+
+// SetUser remembers the authenticated user on the record, so that the
+// revision created by the following save is attributed to them.
+func SetUser(record *core.Record, auth *core.Record) {
+	if auth == nil {
+		return
+	}
+	user := auth.Email()
+	if user == "" {
+		user = auth.Id
+	}
+	record.Set(userKey, user)
+}
+
+// Record stores a revision if the file differs from its latest revision.
+// It expects the app of the record event, so that the revision is written in
+// the same transaction as the file.
+func (h *RevisionsHandler) Record(app core.App, record *core.Record) error {
+	if record.GetString("deleted") != "" {
+		return nil
+	}
+	rawFrontmatter := record.GetString("raw_frontmatter")
+	content := record.GetString("content")
+	path := record.GetString("path")
+	hash := utils.GetDBHash(rawFrontmatter, content)
+
+	db := app.NonconcurrentDB()
+	var latest string
+	err := db.Select("hash").
+		From("revisions").
+		Where(dbx.HashExp{"path": path}).
+		OrderBy("created DESC", "rowid DESC").
+		Limit(1).
+		Row(&latest)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if latest == hash {
+		return nil
+	}
+
+	text := fileText(rawFrontmatter, content)
+	data, err := compress(text)
+	if err != nil {
+		return err
+	}
+	origin := record.GetString("origin")
+	if origin == "" {
+		origin = "db"
+	}
+	_, err = db.Insert("revisions", dbx.Params{
+		"id":      core.GenerateDefaultRandomId(),
+		"file":    record.Id,
+		"path":    path,
+		"data":    data,
+		"hash":    hash,
+		"origin":  origin,
+		"user":    record.GetString(userKey),
+		"size":    len(text),
+		"created": types.NowDateTime().String(),
+	}).Execute()
+	return err
+}
+
+func (h *RevisionsHandler) Routes(se *core.ServeEvent) {
+	revisionsGroup := se.Router.Group("/revisions")
+	revisionsGroup.Bind(apis.RequireSuperuserAuth())
+	revisionsGroup.GET("", func(e *core.RequestEvent) error {
+		query := e.Request.URL.Query()
+		path := query.Get("path")
+		if fileId := query.Get("file"); fileId != "" {
+			record, err := h.app.FindRecordById("files", fileId)
+			if err != nil {
+				return apis.NewNotFoundError("file not found", nil)
+			}
+			path = record.GetString("path")
+		}
+		if path == "" {
+			return apis.NewBadRequestError("file or path is required", nil)
+		}
+		revisions, err := h.List(path)
+		if err != nil {
+			return apis.NewBadRequestError("unable to list revisions", err)
+		}
+		return e.JSON(http.StatusOK, revisions)
+	})
+	revisionsGroup.GET("/diff", func(e *core.RequestEvent) error {
+		query := e.Request.URL.Query()
+		diff, err := h.Diff(query.Get("from"), query.Get("to"))
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, diff)
+	})
+	revisionsGroup.GET("/{id}", func(e *core.RequestEvent) error {
+		revision, err := h.Get(e.Request.PathValue("id"))
+		if err != nil {
+			return apis.NewNotFoundError("revision not found", nil)
+		}
+		return e.JSON(http.StatusOK, revision)
+	})
+	revisionsGroup.POST("/{id}/restore", func(e *core.RequestEvent) error {
+		user := ""
+		if e.Auth != nil {
+			user = e.Auth.Email()
+		}
+		if err := h.Restore(e.Request.PathValue("id"), user); err != nil {
+			return apis.NewBadRequestError("unable to restore revision", err)
+		}
+		return e.NoContent(http.StatusNoContent)
+	})
+}
+
+// List returns the revisions of a path, newest first, without the text.
+func (h *RevisionsHandler) List(path string) ([]Revision, error) {
+	revisions := []Revision{}
+	err := h.app.DB().
+		Select("id", "file", "path", "hash", "origin", "user", "size", "created").
+		From("revisions").
+		Where(dbx.HashExp{"path": path}).
+		OrderBy("created DESC", "rowid DESC").
+		All(&revisions)
+	return revisions, err
+}
+
+func (h *RevisionsHandler) Get(id string) (Revision, error) {
+	revision := Revision{}
+	err := h.app.DB().Select("*").From("revisions").Where(dbx.HashExp{"id": id}).One(&revision)
+	if err != nil {
+		return revision, err
+	}
+	revision.Text, err = decompress(revision.Data)
+	return revision, err
+}
+
+// Diff compares two revisions. Without `to` the revision is compared to the
+// current state of the file.
+func (h *RevisionsHandler) Diff(fromId, toId string) (Diff, error) {
+	from, err := h.Get(fromId)
+	if err != nil {
+		return Diff{}, errors.New("revision not found")
+	}
+	var to Revision
+	if toId == "" {
+		toId = "current"
+		record, err := h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": from.Path})
+		if err == nil {
+			to.Path = from.Path
+			to.Text = fileText(record.GetString("raw_frontmatter"), record.GetString("content"))
+		}
+	} else {
+		to, err = h.Get(toId)
+		if err != nil {
+			return Diff{}, errors.New("revision not found")
+		}
+	}
+	return Diff{
+		From: fromId,
+		To:   toId,
+		Diff: textdiff.Unified(from.Text, to.Text, "a/"+from.Path+"@"+fromId, "b/"+from.Path+"@"+toId, 3),
+	}, nil
+}
+
+// Restore writes a revision back. Existing files are updated through the
+// record, so that the regular OnRecordUpdate flow writes them to disk.
+// Deleted files are written to disk directly and picked up by the watcher.
+func (h *RevisionsHandler) Restore(id, user string) error {
+	revision, err := h.Get(id)
+	if err != nil {
+		return err
+	}
+	extracted := utils.ExtractFrontMatter(revision.Text)
+
+	record, err := h.app.FindFirstRecordByFilter("files", "path = {:path} && deleted = ''", dbx.Params{"path": revision.Path})
+	if err != nil {
+		absPath := filepath.Join(h.root, revision.Path)
+		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+			return err
+		}
+		return utils.SaveToDisk(absPath, extracted.MainContent, extracted.FrontMatter)
+	}
+
+	if err := frontmatter.SetRaw(record, extracted.FrontMatter); err != nil {
+		return err
+	}
+	record.Set("content", extracted.MainContent)
+	record.Set(userKey, user)
+	return h.app.Save(record)
+}
+
+func fileText(rawFrontmatter, content string) string {
+	if rawFrontmatter == "" {
+		return content
+	}
+	return "---\n" + rawFrontmatter + "---\n" + content
+}
+
+func compress(text string) (string, error) {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(text)); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b.Bytes()), nil
+}
+
+func decompress(data string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	text, err := io.ReadAll(r)
+	return string(text), err
+}
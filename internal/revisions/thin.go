@@ -0,0 +1,89 @@
+package revisions
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ScheduleThinning registers the thinning job in the PocketBase cron.
+func (h *RevisionsHandler) ScheduleThinning() {
+	if err := h.app.Cron().Add("revisions_thinning", h.conf.Revisions.Schedule, h.Thin); err != nil {
+		h.app.Logger().Error("unable to schedule revisions thinning", "schedule", h.conf.Revisions.Schedule, "error", err)
+	}
+}
+
+// Thin removes the revisions not covered by the policy from the config.
+// The latest revision of every note is always kept.
+func (h *RevisionsHandler) Thin() {
+	startTime := time.Now()
+	revisions := []Revision{}
+	err := h.app.DB().
+		Select("id", "path", "created").
+		From("revisions").
+		OrderBy("path ASC", "created DESC", "rowid DESC").
+		All(&revisions)
+	if err != nil {
+		h.app.Logger().Error("unable to load revisions", "error", err)
+		return
+	}
+
+	ids := []any{}
+	var (
+		path    string
+		buckets map[string]bool
+	)
+	for _, revision := range revisions {
+		if revision.Path != path {
+			// the latest revision of the note
+			path = revision.Path
+			buckets = map[string]bool{}
+			continue
+		}
+		created, err := types.ParseDateTime(revision.Created)
+		if err != nil {
+			continue
+		}
+		bucket, keep := h.bucket(startTime, created.Time())
+		if keep {
+			continue
+		}
+		if bucket == "" || buckets[bucket] {
+			ids = append(ids, revision.Id)
+			continue
+		}
+		buckets[bucket] = true
+	}
+
+	for start := 0; start < len(ids); start += 500 {
+		end := min(start+500, len(ids))
+		if _, err := h.app.DB().Delete("revisions", dbx.In("id", ids[start:end]...)).Execute(); err != nil {
+			h.app.Logger().Error("unable to delete revisions", "error", err)
+			return
+		}
+	}
+	h.app.Logger().Info("Revisions thinned", "deleted", len(ids), "elapsed", time.Since(startTime).String())
+}
+
+// bucket returns the period a revision represents. Only the newest revision
+// of each period is kept. keep is true for revisions, which are always kept,
+// and an empty bucket means the revision has to be removed.
+func (h *RevisionsHandler) bucket(now, created time.Time) (string, bool) {
+	policy := h.conf.Revisions
+	age := now.Sub(created)
+	switch {
+	case policy.MaxAge > 0 && age > policy.MaxAge:
+		return "", false
+	case age <= policy.KeepAll:
+		return "", true
+	case age <= policy.KeepDaily:
+		return "d" + created.Format("2006-01-02"), false
+	case age <= policy.KeepWeekly:
+		year, week := created.ISOWeek()
+		return fmt.Sprintf("w%d-%d", year, week), false
+	default:
+		return "m" + created.Format("2006-01"), false
+	}
+}
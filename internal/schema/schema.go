@@ -0,0 +1,263 @@
+package schema
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeDate   = "date"
+	TypeList   = "list"
+	TypeObject = "object"
+	TypeAny    = "any"
+)
+
+var knownTypes = map[string]bool{
+	TypeString: true,
+	TypeNumber: true,
+	TypeBool:   true,
+	TypeDate:   true,
+	TypeList:   true,
+	TypeObject: true,
+	TypeAny:    true,
+}
+
+type SchemaHandler struct {
+	app   *pocketbase.PocketBase
+	types map[string]config.TypeConfig
+	conf  *config.NotebaseConfig
+}
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) (*SchemaHandler, error) {
+	types := make(map[string]config.TypeConfig, len(conf.Types))
+	for _, t := range conf.Types {
+		if t.Name == "" {
+			return nil, fmt.Errorf("type name is required")
+		}
+		for _, field := range t.Fields {
+			if err := checkField(field); err != nil {
+				return nil, fmt.Errorf("type %s: %w", t.Name, err)
+			}
+		}
+		types[t.Name] = t
+	}
+	return &SchemaHandler{
+		app:   app,
+		types: types,
+		conf:  conf,
+	}, nil
+}
+
+func checkField(field config.FieldConfig) error {
+	if field.Type == "" {
+		field.Type = TypeAny
+	}
+	if !knownTypes[field.Type] {
+		return fmt.Errorf("field %s: unknown type %q", field.Name, field.Type)
+	}
+	if field.Items != nil {
+		if err := checkField(*field.Items); err != nil {
+			return fmt.Errorf("field %s items: %w", field.Name, err)
+		}
+	}
+	for _, nested := range field.Fields {
+		if err := checkField(nested); err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+	}
+	return nil
+}
+
+func (h *SchemaHandler) Routes(se *core.ServeEvent) {
+	typesGroup := se.Router.Group("/types")
+	typesGroup.Bind(apis.RequireAuth())
+	typesGroup.GET("", func(e *core.RequestEvent) error {
+		return e.JSON(http.StatusOK, h.conf.Types)
+	})
+	typesGroup.GET("/{name}", func(e *core.RequestEvent) error {
+		t, ok := h.types[e.Request.PathValue("name")]
+		if !ok {
+			return apis.NewNotFoundError("unknown type", nil)
+		}
+		return e.JSON(http.StatusOK, t)
+	})
+}
+
+// Type returns the schema of the note type, if it is defined in the config.
+func (h *SchemaHandler) Type(name string) (config.TypeConfig, bool) {
+	t, ok := h.types[name]
+	return t, ok
+}
+
+// Annotate stores validation errors of the record frontmatter on the record
+// itself. It is called for every save, including the ones from the sync.
+func (h *SchemaHandler) Annotate(record *core.Record) {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		record.Set("validation_errors", map[string]string{"frontmatter": err.Error()})
+		return
+	}
+	errs := h.Validate(fm.Map())
+	if len(errs) == 0 {
+		record.Set("validation_errors", nil)
+		return
+	}
+	record.Set("validation_errors", errs)
+}
+
+// ValidateRequest fills in the defaults and rejects invalid frontmatter
+// coming from the API. Unchanged frontmatter is not validated, so that
+// content can be edited even if the note is already invalid.
+func (h *SchemaHandler) ValidateRequest(record *core.Record) error {
+	if !record.IsNew() && record.GetString("frontmatter") == record.Original().GetString("frontmatter") {
+		return nil
+	}
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return apis.NewBadRequestError("invalid frontmatter", map[string]string{"frontmatter": err.Error()})
+	}
+	if t, ok := h.types[fm.GetString("type")]; ok {
+		changed := false
+		for _, field := range t.Fields {
+			if _, exists := fm.Get(field.Name); !exists && field.Default != nil {
+				fm.Set(field.Name, field.Default)
+				changed = true
+			}
+		}
+		if changed {
+			raw, err := fm.JSON()
+			if err != nil {
+				return apis.NewBadRequestError("invalid frontmatter", map[string]string{"frontmatter": err.Error()})
+			}
+			record.Set("frontmatter", raw)
+		}
+	}
+	if errs := h.Validate(fm.Map()); len(errs) > 0 {
+		return apis.NewBadRequestError("invalid frontmatter", apiErrors(errs))
+	}
+	return nil
+}
+
+func apiErrors(errs map[string]string) validation.Errors {
+	result := make(validation.Errors, len(errs))
+	for path, message := range errs {
+		result[path] = validation.NewError("validation_invalid_frontmatter", message)
+	}
+	return result
+}
+
+// Validate checks the frontmatter against the schema of its type.
+// Notes without a type or with a type missing in the config are always valid.
+// The result maps field paths (like `transactions.0.amount`) to error messages.
+func (h *SchemaHandler) Validate(fm map[string]any) map[string]string {
+	errs := map[string]string{}
+	typeName, _ := fm["type"].(string)
+	t, ok := h.types[typeName]
+	if !ok {
+		return errs
+	}
+	validateFields(errs, "", t.Fields, fm)
+	return errs
+}
+
+func validateFields(errs map[string]string, prefix string, fields []config.FieldConfig, values map[string]any) {
+	for _, field := range fields {
+		value, exists := values[field.Name]
+		validateValue(errs, prefix+field.Name, field, value, exists)
+	}
+}
+
+func validateValue(errs map[string]string, path string, field config.FieldConfig, value any, exists bool) {
+	if !exists || value == nil || value == "" {
+		if field.Required {
+			errs[path] = "is required"
+		}
+		return
+	}
+
+	switch field.Type {
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			errs[path] = "must be a string"
+			return
+		}
+	case TypeNumber:
+		if _, ok := value.(float64); !ok {
+			errs[path] = "must be a number"
+			return
+		}
+	case TypeBool:
+		if _, ok := value.(bool); !ok {
+			errs[path] = "must be a boolean"
+			return
+		}
+	case TypeDate:
+		s, ok := value.(string)
+		if !ok {
+			errs[path] = "must be a date"
+			return
+		}
+		if _, ok := utils.ParseDate(s, time.UTC); !ok {
+			errs[path] = "must be a date"
+			return
+		}
+	case TypeList:
+		items, ok := value.([]any)
+		if !ok {
+			errs[path] = "must be a list"
+			return
+		}
+		for i, item := range items {
+			itemPath := fmt.Sprintf("%s.%d", path, i)
+			if field.Items != nil {
+				validateValue(errs, itemPath, *field.Items, item, true)
+			}
+			if len(field.Enum) > 0 && !inEnum(field.Enum, item) {
+				errs[itemPath] = "must be one of " + enumString(field.Enum)
+			}
+		}
+		return
+	case TypeObject:
+		object, ok := value.(map[string]any)
+		if !ok {
+			errs[path] = "must be an object"
+			return
+		}
+		validateFields(errs, path+".", field.Fields, object)
+		return
+	}
+
+	if len(field.Enum) > 0 && !inEnum(field.Enum, value) {
+		errs[path] = "must be one of " + enumString(field.Enum)
+	}
+}
+
+func inEnum(enum []any, value any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(frontmatter.Plain(e)) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumString(enum []any) string {
+	values := make([]string, 0, len(enum))
+	for _, e := range enum {
+		values = append(values, fmt.Sprint(e))
+	}
+	return strings.Join(values, ", ")
+}
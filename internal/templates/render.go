@@ -0,0 +1,156 @@
+package templates
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/moment"
+)
+
+// The syntax follows the Obsidian core Templates plugin: {{title}}, {{date}},
+// {{time}} and {{date:FORMAT}}. On top of it notebase supports offsets like
+// {{date+1d:FORMAT}}, {{yesterday}}/{{tomorrow}} from the Periodic Notes
+// plugin. Every other placeholder is a prompt, whose value is provided when
+// the note is created, {{name|default}} sets a default value.
+var placeholderRe = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+var dateRe = regexp.MustCompile(`^(date|time)(?:([+-]\d+)([yMwdhm]))?(?::(.*))?$`)
+
+type Variable struct {
+	Name    string `json:"name"`
+	Default string `json:"default,omitempty"`
+	// set for {{name|}}, which may be left empty
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Context is the data available to the placeholders.
+type Context struct {
+	Title      string
+	Now        time.Time
+	Values     map[string]string
+	DateFormat string
+	TimeFormat string
+}
+
+// Variables returns the prompts of a template in order of appearance.
+func Variables(text string) []Variable {
+	variables := []Variable{}
+	seen := map[string]bool{}
+	for _, m := range placeholderRe.FindAllStringSubmatch(text, -1) {
+		variable, ok := prompt(m[1])
+		if !ok || seen[variable.Name] {
+			continue
+		}
+		seen[variable.Name] = true
+		variables = append(variables, variable)
+	}
+	return variables
+}
+
+// prompt parses a placeholder, which is not one of the builtins.
+func prompt(placeholder string) (Variable, bool) {
+	switch placeholder {
+	case "title", "yesterday", "tomorrow":
+		return Variable{}, false
+	}
+	if dateRe.MatchString(placeholder) {
+		return Variable{}, false
+	}
+	placeholder = strings.TrimPrefix(placeholder, "prompt:")
+	name, def, optional := strings.Cut(placeholder, "|")
+	return Variable{Name: strings.TrimSpace(name), Default: strings.TrimSpace(def), Optional: optional}, true
+}
+
+// Render substitutes the placeholders. Values inserted into the frontmatter
+// are quoted, when they would break the YAML otherwise. Prompts without
+// a value and a default are returned as missing.
+func Render(rawFrontmatter, content string, ctx Context) (string, string, []string) {
+	missing := []string{}
+	seen := map[string]bool{}
+	replace := func(text string, frontmatter bool) string {
+		return placeholderRe.ReplaceAllStringFunc(text, func(m string) string {
+			placeholder := placeholderRe.FindStringSubmatch(m)[1]
+			value, builtin := ctx.builtin(placeholder)
+			if !builtin {
+				variable, _ := prompt(placeholder)
+				v, ok := ctx.Values[variable.Name]
+				if !ok || v == "" {
+					v = variable.Default
+				}
+				if v == "" && !ok && !variable.Optional {
+					if !seen[variable.Name] {
+						seen[variable.Name] = true
+						missing = append(missing, variable.Name)
+					}
+					return m
+				}
+				value = v
+			}
+			if frontmatter && (!builtin || placeholder == "title") && needsQuotes(value) {
+				return strconv.Quote(value)
+			}
+			return value
+		})
+	}
+	return replace(rawFrontmatter, true), replace(content, false), missing
+}
+
+func (ctx Context) builtin(placeholder string) (string, bool) {
+	switch placeholder {
+	case "title":
+		return ctx.Title, true
+	case "yesterday":
+		return moment.Format(ctx.Now.AddDate(0, 0, -1), ctx.DateFormat), true
+	case "tomorrow":
+		return moment.Format(ctx.Now.AddDate(0, 0, 1), ctx.DateFormat), true
+	}
+	m := dateRe.FindStringSubmatch(placeholder)
+	if m == nil {
+		return "", false
+	}
+	layout := ctx.DateFormat
+	if m[1] == "time" {
+		layout = ctx.TimeFormat
+	}
+	if m[4] != "" {
+		layout = m[4]
+	}
+	t := ctx.Now
+	if m[2] != "" {
+		n, _ := strconv.Atoi(m[2])
+		switch m[3] {
+		case "y":
+			t = t.AddDate(n, 0, 0)
+		case "M":
+			t = t.AddDate(0, n, 0)
+		case "w":
+			t = t.AddDate(0, 0, 7*n)
+		case "d":
+			t = t.AddDate(0, 0, n)
+		case "h":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "m":
+			t = t.Add(time.Duration(n) * time.Minute)
+		}
+	}
+	return moment.Format(t, layout), true
+}
+
+// needsQuotes is a conservative check for YAML plain scalars.
+func needsQuotes(value string) bool {
+	if value == "" || strings.TrimSpace(value) != value {
+		return true
+	}
+	if strings.ContainsAny(value, "\n\"") || strings.Contains(value, ": ") || strings.Contains(value, " #") {
+		return true
+	}
+	return strings.ContainsAny(value[:1], "-?:,[]{}#&*!|>'%@`")
+}
+
+// Title returns the default title of a note, which is its file name.
+func Title(path string) string {
+	name := path[strings.LastIndex(path, "/")+1:]
+	return strings.TrimSuffix(name, ".md")
+}
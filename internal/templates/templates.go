@@ -0,0 +1,247 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/utils"
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// NoteCreator writes a new note to disk and loads it into the files table.
+type NoteCreator interface {
+	CreateNote(relPath, rawFrontmatter, content string) (*core.Record, error)
+}
+
+type TemplatesHandler struct {
+	app   *pocketbase.PocketBase
+	root  string
+	conf  *config.NotebaseConfig
+	notes NoteCreator
+}
+
+// Template is a note in the templates folder, the name is its path relative
+// to the folder without the extension.
+type Template struct {
+	Name           string     `json:"name"`
+	Path           string     `json:"path"`
+	Variables      []Variable `json:"variables"`
+	RawFrontmatter string     `json:"raw_frontmatter,omitempty"`
+	Content        string     `json:"content,omitempty"`
+}
+
+type Rendered struct {
+	Template       string          `json:"template"`
+	Path           string          `json:"path,omitempty"`
+	RawFrontmatter string          `json:"raw_frontmatter"`
+	Frontmatter    json.RawMessage `json:"frontmatter"`
+	Content        string          `json:"content"`
+}
+
+type RenderRequest struct {
+	Template string            `json:"template"`
+	Path     string            `json:"path"`
+	Title    string            `json:"title"`
+	Values   map[string]string `json:"values"`
+	// Now is the date of the placeholders, the current time by default.
+	Now time.Time `json:"-"`
+}
+
+var ErrNotFound = errors.New("template not found")
+
+// MissingError is returned, when prompts have neither a value nor a default.
+type MissingError struct {
+	Variables []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("missing values for %s", strings.Join(e.Variables, ", "))
+}
+
+func NewHandler(app *pocketbase.PocketBase, root string, conf *config.NotebaseConfig, notes NoteCreator) *TemplatesHandler {
+	return &TemplatesHandler{
+		app:   app,
+		root:  root,
+		conf:  conf,
+		notes: notes,
+	}
+}
+
+func (h *TemplatesHandler) folder() string {
+	return filepath.Join(h.root, h.conf.Templates.Folder)
+}
+
+// List returns all templates without their text.
+func (h *TemplatesHandler) List() ([]Template, error) {
+	templates := []Template{}
+	err := filepath.WalkDir(h.folder(), func(walkPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipAll
+			}
+			return err
+		}
+		if d.IsDir() || filepath.Ext(walkPath) != ".md" {
+			return nil
+		}
+		rel, _ := filepath.Rel(h.folder(), walkPath)
+		tpl, err := h.Get(strings.TrimSuffix(rel, ".md"))
+		if err != nil {
+			return err
+		}
+		tpl.RawFrontmatter = ""
+		tpl.Content = ""
+		templates = append(templates, tpl)
+		return nil
+	})
+	return templates, err
+}
+
+func (h *TemplatesHandler) Get(name string) (Template, error) {
+	name = strings.TrimSuffix(filepath.Clean(name), ".md")
+	absPath := filepath.Join(h.folder(), name+".md")
+	if !strings.HasPrefix(absPath, h.folder()+string(filepath.Separator)) {
+		return Template{}, ErrNotFound
+	}
+	b, err := os.ReadFile(absPath)
+	if err != nil {
+		return Template{}, ErrNotFound
+	}
+	extracted := utils.ExtractFrontMatter(string(b))
+	rel, _ := filepath.Rel(h.root, absPath)
+	return Template{
+		Name:           name,
+		Path:           rel,
+		Variables:      Variables(extracted.FrontMatter + extracted.MainContent),
+		RawFrontmatter: extracted.FrontMatter,
+		Content:        extracted.MainContent,
+	}, nil
+}
+
+// Render fills in a template. The title defaults to the file name of the
+// note, which is being created.
+func (h *TemplatesHandler) Render(req RenderRequest) (Rendered, error) {
+	tpl, err := h.Get(req.Template)
+	if err != nil {
+		return Rendered{}, err
+	}
+	title := req.Title
+	if title == "" && req.Path != "" {
+		title = Title(req.Path)
+	}
+	now := req.Now
+	if now.IsZero() {
+		now = time.Now().In(h.conf.Location())
+	}
+	rawFrontmatter, content, missing := Render(tpl.RawFrontmatter, tpl.Content, Context{
+		Title:      title,
+		Now:        now,
+		Values:     req.Values,
+		DateFormat: h.conf.Templates.DateFormat,
+		TimeFormat: h.conf.Templates.TimeFormat,
+	})
+	if len(missing) > 0 {
+		return Rendered{}, &MissingError{Variables: missing}
+	}
+	fm, err := frontmatter.Parse(rawFrontmatter)
+	if err != nil {
+		return Rendered{}, fmt.Errorf("rendered frontmatter is not valid YAML: %w", err)
+	}
+	frontmatterJSON, err := fm.JSON()
+	if err != nil {
+		return Rendered{}, err
+	}
+	return Rendered{
+		Template:       tpl.Name,
+		Path:           req.Path,
+		RawFrontmatter: rawFrontmatter,
+		Frontmatter:    json.RawMessage(frontmatterJSON),
+		Content:        content,
+	}, nil
+}
+
+// Create renders a template into a new note.
+func (h *TemplatesHandler) Create(req RenderRequest) (*core.Record, error) {
+	if req.Path == "" {
+		if req.Title == "" {
+			return nil, errors.New("path or title is required")
+		}
+		req.Path = req.Title
+	}
+	if filepath.Ext(req.Path) != ".md" {
+		req.Path += ".md"
+	}
+	rendered, err := h.Render(req)
+	if err != nil {
+		return nil, err
+	}
+	return h.notes.CreateNote(req.Path, rendered.RawFrontmatter, rendered.Content)
+}
+
+func (h *TemplatesHandler) Routes(se *core.ServeEvent) {
+	templatesGroup := se.Router.Group("/templates")
+	templatesGroup.Bind(apis.RequireSuperuserAuth())
+	templatesGroup.GET("", func(e *core.RequestEvent) error {
+		templates, err := h.List()
+		if err != nil {
+			return apis.NewBadRequestError("unable to list templates", err)
+		}
+		return e.JSON(http.StatusOK, templates)
+	})
+	templatesGroup.GET("/{name...}", func(e *core.RequestEvent) error {
+		tpl, err := h.Get(e.Request.PathValue("name"))
+		if err != nil {
+			return apis.NewNotFoundError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, tpl)
+	})
+	templatesGroup.POST("/render", func(e *core.RequestEvent) error {
+		req := RenderRequest{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		rendered, err := h.Render(req)
+		if err != nil {
+			return renderError(err)
+		}
+		return e.JSON(http.StatusOK, rendered)
+	})
+	templatesGroup.POST("/create", func(e *core.RequestEvent) error {
+		req := RenderRequest{}
+		if err := e.BindBody(&req); err != nil {
+			return apis.NewBadRequestError("invalid request", err)
+		}
+		record, err := h.Create(req)
+		if err != nil {
+			return renderError(err)
+		}
+		return e.JSON(http.StatusOK, record)
+	})
+}
+
+func renderError(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return apis.NewNotFoundError(err.Error(), nil)
+	}
+	missing := &MissingError{}
+	if errors.As(err, &missing) {
+		errs := make(validation.Errors, len(missing.Variables))
+		for _, name := range missing.Variables {
+			errs[name] = validation.NewError("validation_required", "Missing required value.")
+		}
+		return apis.NewBadRequestError(err.Error(), errs)
+	}
+	return apis.NewBadRequestError(err.Error(), nil)
+}
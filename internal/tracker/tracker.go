@@ -0,0 +1,255 @@
+package tracker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/frontmatter"
+	"github.com/biozz/wow/notebase/internal/recurrence"
+	"github.com/biozz/wow/notebase/internal/revisions"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/goccy/go-yaml"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/spf13/cobra"
+)
+
+const (
+	ActionEpisode  = "episode"
+	ActionSeason   = "season"
+	ActionComplete = "complete"
+	ActionDrop     = "drop"
+)
+
+const (
+	StatusWatching  = "watching"
+	StatusCompleted = "completed"
+	StatusDropped   = "dropped"
+	StatusPlanned   = "planned"
+)
+
+// Notes resolves the note arguments of the CLI.
+type Notes interface {
+	Note(arg string) (*core.Record, error)
+	CommitPending()
+}
+
+type TrackerHandler struct {
+	app   *pocketbase.PocketBase
+	conf  *config.NotebaseConfig
+	notes Notes
+}
+
+type Options struct {
+	// Count is the number of watched episodes, 1 by default.
+	Count int `json:"count"`
+	// NextEpisode overrides the air date computed from the cadence.
+	NextEpisode string `json:"next_episode"`
+}
+
+var ErrNotTracked = errors.New("note is not tracked")
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig, notes Notes) *TrackerHandler {
+	return &TrackerHandler{
+		app:   app,
+		conf:  conf,
+		notes: notes,
+	}
+}
+
+// Apply changes the frontmatter of a track note according to the action and
+// logs every step in `history`. Watching the last episode of a season (the
+// `episodes` key) starts the next one, unless it is the last season
+// (the `seasons` key), then the show is completed.
+func (h *TrackerHandler) Apply(fm *frontmatter.Frontmatter, action string, opts Options, now time.Time) error {
+	if fm.GetString("type") != h.conf.Tracker.Type {
+		return ErrNotTracked
+	}
+	season := intValue(fm, "season", 1)
+	episode := intValue(fm, "episode", 0)
+	episodes := intValue(fm, "episodes", 0)
+	seasons := intValue(fm, "seasons", 0)
+
+	switch action {
+	case ActionEpisode:
+		count := max(opts.Count, 1)
+		episode += count
+		fm.Set("episode", episode)
+		if status := fm.GetString("status"); status == "" || status == StatusPlanned {
+			fm.Set("status", StatusWatching)
+		}
+		next, err := h.nextEpisode(fm, opts.NextEpisode, count)
+		if err != nil {
+			return err
+		}
+		fm.Set("next_episode", next)
+		logStep(fm, now, ActionEpisode, season, episode)
+		if episodes == 0 || episode < episodes {
+			return nil
+		}
+		if seasons == 0 || season >= seasons {
+			complete(fm, now, season, episode)
+			return nil
+		}
+		// the air date from the cadence or the override is kept for the
+		// first episode of the next season
+		startSeason(fm, now, season+1, next)
+	case ActionSeason:
+		startSeason(fm, now, season+1, opts.NextEpisode)
+	case ActionComplete:
+		complete(fm, now, season, episode)
+	case ActionDrop:
+		fm.Set("status", StatusDropped)
+		fm.Set("next_episode", "")
+		logStep(fm, now, ActionDrop, season, episode)
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	return nil
+}
+
+// nextEpisode moves the air date by the cadence once per watched episode.
+func (h *TrackerHandler) nextEpisode(fm *frontmatter.Frontmatter, override string, count int) (string, error) {
+	if override != "" {
+		if _, ok := utils.ParseDate(override, h.conf.Location()); !ok {
+			return "", fmt.Errorf("invalid next episode date %q", override)
+		}
+		return override, nil
+	}
+	cadence := fm.GetString("cadence")
+	if cadence == "" {
+		cadence = h.conf.Tracker.Cadence
+	}
+	current := fm.GetString("next_episode")
+	if cadence == "none" {
+		return current, nil
+	}
+	rule, err := recurrence.Parse(cadence)
+	if err != nil {
+		return "", fmt.Errorf("invalid cadence: %w", err)
+	}
+	loc := h.conf.Location()
+	next, ok := utils.ParseDate(current, loc)
+	if !ok {
+		now := time.Now().In(loc)
+		next = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+		count--
+	}
+	for range count {
+		next = rule.Next(next)
+	}
+	return next.Format(time.DateOnly), nil
+}
+
+func startSeason(fm *frontmatter.Frontmatter, now time.Time, season int, nextEpisode string) {
+	fm.Set("season", season)
+	fm.Set("episode", 0)
+	fm.Set("status", StatusWatching)
+	fm.Set("next_episode", nextEpisode)
+	logStep(fm, now, ActionSeason, season, 0)
+}
+
+func complete(fm *frontmatter.Frontmatter, now time.Time, season, episode int) {
+	fm.Set("status", StatusCompleted)
+	fm.Set("completed", now.Format("2006-01-02T15:04:05"))
+	fm.Set("next_episode", "")
+	logStep(fm, now, ActionComplete, season, episode)
+}
+
+func logStep(fm *frontmatter.Frontmatter, now time.Time, action string, season, episode int) {
+	value, _ := fm.Get("history")
+	history, _ := value.([]any)
+	fm.Set("history", append(history, yaml.MapSlice{
+		{Key: "date", Value: now.Format("2006-01-02T15:04:05")},
+		{Key: "action", Value: action},
+		{Key: "season", Value: season},
+		{Key: "episode", Value: episode},
+	}))
+}
+
+func intValue(fm *frontmatter.Frontmatter, key string, def int) int {
+	n, err := strconv.Atoi(fm.GetString(key))
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// Track applies the action to a note and saves it, the regular
+// OnRecordUpdate flow writes the note to disk.
+func (h *TrackerHandler) Track(record *core.Record, action string, opts Options) error {
+	fm, err := frontmatter.FromRecord(record)
+	if err != nil {
+		return err
+	}
+	if err := h.Apply(fm, action, opts, time.Now().In(h.conf.Location())); err != nil {
+		return err
+	}
+	return fm.Save(h.app, record)
+}
+
+func (h *TrackerHandler) Routes(se *core.ServeEvent) {
+	trackerGroup := se.Router.Group("/tracker")
+	trackerGroup.Bind(apis.RequireSuperuserAuth())
+	trackerGroup.POST("/{id}/{action}", func(e *core.RequestEvent) error {
+		record, err := h.app.FindRecordById("files", e.Request.PathValue("id"))
+		if err != nil || record.GetString("deleted") != "" {
+			return apis.NewNotFoundError("file not found", nil)
+		}
+		opts := Options{}
+		if e.Request.ContentLength > 0 {
+			if err := e.BindBody(&opts); err != nil {
+				return apis.NewBadRequestError("invalid request", err)
+			}
+		}
+		revisions.SetUser(record, e.Auth)
+		if err := h.Track(record, e.Request.PathValue("action"), opts); err != nil {
+			return apis.NewBadRequestError(err.Error(), nil)
+		}
+		return e.JSON(http.StatusOK, record)
+	})
+}
+
+func (h *TrackerHandler) TrackCmd() *cobra.Command {
+	opts := Options{}
+	cmd := &cobra.Command{
+		Use:          "track <note> [episode|season|complete|drop]",
+		SilenceUsage: true,
+		Short:        "Apply an episode tracking action to a track note",
+		Example: `  notebase track activities/lazarus.md
+  notebase track activities/lazarus.md episode --count 3
+  notebase track activities/lazarus.md season --next-episode 2025-10-01`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			action := ActionEpisode
+			if len(args) == 2 {
+				action = args[1]
+			}
+			if err := h.app.RunAllMigrations(); err != nil {
+				return err
+			}
+			record, err := h.notes.Note(args[0])
+			if err != nil {
+				return err
+			}
+			if err := h.Track(record, action, opts); err != nil {
+				return err
+			}
+			h.notes.CommitPending()
+			fm, err := frontmatter.FromRecord(record)
+			if err != nil {
+				return err
+			}
+			cmd.Printf("%s: season %s, episode %s, %s\n", record.GetString("path"), fm.GetString("season"), fm.GetString("episode"), fm.GetString("status"))
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&opts.Count, "count", 1, "number of watched episodes")
+	cmd.Flags().StringVar(&opts.NextEpisode, "next-episode", "", "air date of the next episode")
+	return cmd
+}
@@ -4,7 +4,9 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -12,17 +14,32 @@ import (
 	"github.com/goccy/go-yaml"
 	"github.com/pkg/xattr"
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
 )
 
 func GetSetting(app *pocketbase.PocketBase, key string) string {
 	rec, err := app.FindFirstRecordByData("settings", "key", key)
 	if err != nil {
-		app.Logger().Error("unable to find setting", err)
+		app.Logger().Error("unable to find setting", "error", err)
 		return ""
 	}
 	return rec.GetString("value")
 }
 
+func SetSetting(app *pocketbase.PocketBase, key, value string) error {
+	rec, err := app.FindFirstRecordByData("settings", "key", key)
+	if err != nil {
+		settingsCol, err := app.FindCollectionByNameOrId("settings")
+		if err != nil {
+			return err
+		}
+		rec = core.NewRecord(settingsCol)
+		rec.Set("key", key)
+	}
+	rec.Set("value", value)
+	return app.Save(rec)
+}
+
 func Debounce[T any](input <-chan T, duration time.Duration) <-chan T {
 	out := make(chan T)
 	go func() {
@@ -126,6 +143,10 @@ func JsonToYaml(jsonRaw string) string {
 		fmt.Println(err)
 		return ""
 	}
+	if len(yamlData) == 0 {
+		// no frontmatter, not an empty `{}` one
+		return ""
+	}
 	yamlBytes, err := yaml.Marshal(yamlData)
 	if err != nil {
 		fmt.Println(err)
@@ -134,6 +155,23 @@ func JsonToYaml(jsonRaw string) string {
 	return string(yamlBytes)
 }
 
+// YamlToJson converts raw frontmatter to JSON the same way the files are
+// parsed during sync. JSON is valid YAML, so it also normalizes JSON. Empty
+// input is not special cased, it converts to the same JSON as `{}`, so that
+// notes without frontmatter compare equal to their empty JSON and are not
+// rewritten.
+func YamlToJson(yamlRaw string) (string, error) {
+	yamlData := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(yamlRaw), &yamlData); err != nil {
+		return "", err
+	}
+	jsonBytes, err := yaml.MarshalWithOptions(yamlData, yaml.JSON())
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
 func IsExcluded(patterns []glob.Glob, path string) bool {
 	for _, pattern := range patterns {
 		if pattern.Match(path) {
@@ -143,6 +181,22 @@ func IsExcluded(patterns []glob.Glob, path string) bool {
 	return false
 }
 
+// Within reports whether path stays inside of dir, both are either relative
+// or absolute. A relative path is checked against the notes root with ".".
+func Within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// EscapeLike escapes the wildcards of a LIKE pattern with a backslash, the
+// pattern must be matched with `ESCAPE '\'`, which the `~` filter operator
+// does.
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func RemoveFileXAttrVersion(filePath string) error {
 	// Remove only the Linux style user namespace key
 	return xattr.Remove(filePath, "user.notebase.version")
@@ -188,3 +242,47 @@ func GetFSHash(filePath string) string {
 	h.Write(result)
 	return hex.EncodeToString(h.Sum(nil))
 }
+
+// dateLayouts are the date formats found in frontmatter, mostly written by
+// Obsidian and the Linter plugin.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseDate parses a frontmatter date, dates without a timezone are
+// interpreted in loc.
+func ParseDate(value string, loc *time.Location) (time.Time, bool) {
+	t, _, ok := ParseDateLayout(value, loc)
+	return t, ok
+}
+
+// ParseDateLayout is ParseDate, which also returns the matching layout, so
+// that a changed date can be written back in the format of the note.
+func ParseDateLayout(value string, loc *time.Location) (time.Time, string, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, layout, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// Number keeps whole numbers as integers, so that they are written to YAML
+// as `4` and not as `4.0`.
+func Number(n float64) any {
+	if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+		return int64(n)
+	}
+	return n
+}
+
+// Round rounds an amount to cents.
+func Round(n float64) float64 {
+	return math.Round(n*100) / 100
+}
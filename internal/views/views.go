@@ -0,0 +1,193 @@
+package views
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/utils"
+	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Names of the view collections created from the config are kept in the
+// settings table, so that views removed from the config can be dropped
+// without touching collections created by hand or by migrations.
+const managedViewsSetting = "managed_views"
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type ViewsHandler struct {
+	app  *pocketbase.PocketBase
+	conf *config.NotebaseConfig
+}
+
+func NewHandler(app *pocketbase.PocketBase, conf *config.NotebaseConfig) *ViewsHandler {
+	return &ViewsHandler{
+		app:  app,
+		conf: conf,
+	}
+}
+
+// Sync creates, updates or drops view collections to match the config.
+// Errors in a single view are logged and don't prevent the others from syncing.
+func (h *ViewsHandler) Sync() {
+	previous := h.managedViews()
+	current := make([]string, 0, len(h.conf.Views))
+
+	for _, view := range h.conf.Views {
+		if err := h.upsert(view); err != nil {
+			h.app.Logger().Error("unable to sync view", "name", view.Name, "error", err)
+			if previous[view.Name] {
+				// keep it managed, so that it can be dropped later
+				current = append(current, view.Name)
+				delete(previous, view.Name)
+			}
+			continue
+		}
+		current = append(current, view.Name)
+		delete(previous, view.Name)
+	}
+
+	for name := range previous {
+		if err := h.drop(name); err != nil {
+			h.app.Logger().Error("unable to drop view", "name", name, "error", err)
+			current = append(current, name)
+			continue
+		}
+		h.app.Logger().Info("view dropped", "name", name)
+	}
+
+	sort.Strings(current)
+	value, _ := json.Marshal(current)
+	if err := utils.SetSetting(h.app, managedViewsSetting, string(value)); err != nil {
+		h.app.Logger().Error("unable to save managed views", "error", err)
+	}
+}
+
+func (h *ViewsHandler) managedViews() map[string]bool {
+	names := []string{}
+	if value := utils.GetSetting(h.app, managedViewsSetting); value != "" {
+		_ = json.Unmarshal([]byte(value), &names)
+	}
+	managed := make(map[string]bool, len(names))
+	for _, name := range names {
+		managed[name] = true
+	}
+	return managed
+}
+
+func (h *ViewsHandler) upsert(view config.ViewConfig) error {
+	if !identifier.MatchString(view.Name) {
+		return fmt.Errorf("invalid view name %q", view.Name)
+	}
+	query, err := BuildQuery(view)
+	if err != nil {
+		return err
+	}
+
+	collection, err := h.app.FindCollectionByNameOrId(view.Name)
+	if err != nil {
+		collection = core.NewViewCollection(view.Name)
+	} else if !collection.IsView() {
+		return fmt.Errorf("collection %q already exists and is not a view", view.Name)
+	} else if collection.ViewQuery == query {
+		return nil
+	}
+
+	collection.ViewQuery = query
+	if err := h.app.Save(collection); err != nil {
+		return err
+	}
+	h.app.Logger().Info("view synced", "name", view.Name)
+	return nil
+}
+
+func (h *ViewsHandler) drop(name string) error {
+	collection, err := h.app.FindCollectionByNameOrId(name)
+	if err != nil {
+		// already gone
+		return nil
+	}
+	if !collection.IsView() {
+		return fmt.Errorf("collection %q is not a view", name)
+	}
+	return h.app.Delete(collection)
+}
+
+// BuildQuery returns the raw query of the view as is, or generates one from
+// the structured filter, for example:
+//
+//	folder: todo/tasks/
+//	where: {type: task}
+//	fields: [summary, due]
+func BuildQuery(view config.ViewConfig) (string, error) {
+	if view.Query != "" {
+		return strings.TrimSpace(view.Query), nil
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT\n  id,\n  path,\n  slug")
+	for _, field := range view.Fields {
+		if !identifier.MatchString(field) {
+			return "", fmt.Errorf("invalid field name %q", field)
+		}
+		fmt.Fprintf(&b, ",\n  json(frontmatter)->'%s' as %s", field, field)
+	}
+	b.WriteString(",\n  content\nFROM files\nWHERE deleted = ''")
+
+	if view.Folder != "" {
+		folder := strings.TrimSuffix(view.Folder, "/") + "/"
+		// a prefix instead of LIKE, so that % and _ in the folder are not
+		// wildcards, the view query parser does not accept ESCAPE '\'
+		fmt.Fprintf(&b, "\n  AND substr(path, 1, %d) = %s", utf8.RuneCountInString(folder), quote(folder))
+	}
+
+	keys := make([]string, 0, len(view.Where))
+	for key := range view.Where {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !identifier.MatchString(key) {
+			return "", fmt.Errorf("invalid where key %q", key)
+		}
+		extract := fmt.Sprintf("json_extract(frontmatter, '$.%s')", key)
+		switch value := view.Where[key].(type) {
+		case nil:
+			fmt.Fprintf(&b, "\n  AND %s IS NULL", extract)
+		case []any:
+			values := make([]string, 0, len(value))
+			for _, v := range value {
+				values = append(values, literal(v))
+			}
+			fmt.Fprintf(&b, "\n  AND %s IN (%s)", extract, strings.Join(values, ", "))
+		default:
+			fmt.Fprintf(&b, "\n  AND %s = %s", extract, literal(value))
+		}
+	}
+
+	return b.String(), nil
+}
+
+func literal(value any) string {
+	switch v := value.(type) {
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case int, int64, uint64, float64:
+		return fmt.Sprint(v)
+	default:
+		return quote(fmt.Sprint(v))
+	}
+}
+
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -9,9 +9,24 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/biozz/wow/notebase/internal/bulk"
 	"github.com/biozz/wow/notebase/internal/caldav"
 	"github.com/biozz/wow/notebase/internal/config"
+	"github.com/biozz/wow/notebase/internal/debts"
+	"github.com/biozz/wow/notebase/internal/goals"
+	"github.com/biozz/wow/notebase/internal/groceries"
+	"github.com/biozz/wow/notebase/internal/habits"
 	"github.com/biozz/wow/notebase/internal/notebasesync"
+	"github.com/biozz/wow/notebase/internal/periodic"
+	"github.com/biozz/wow/notebase/internal/properties"
+	"github.com/biozz/wow/notebase/internal/query"
+	"github.com/biozz/wow/notebase/internal/recurrence"
+	"github.com/biozz/wow/notebase/internal/replace"
+	"github.com/biozz/wow/notebase/internal/revisions"
+	"github.com/biozz/wow/notebase/internal/schema"
+	"github.com/biozz/wow/notebase/internal/templates"
+	"github.com/biozz/wow/notebase/internal/tracker"
+	"github.com/biozz/wow/notebase/internal/views"
 	_ "github.com/biozz/wow/notebase/migrations"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	"github.com/pocketbase/pocketbase"
@@ -46,7 +61,30 @@ func main() {
 		app.Logger().Error("error creating sync handler", "error", err)
 		return
 	}
-	caldavHandler := caldav.NewHandler(app, root, &conf)
+	schemaHandler, err := schema.NewHandler(app, &conf)
+	if err != nil {
+		app.Logger().Error("error loading note types", "error", err)
+		return
+	}
+	caldavHandler := caldav.NewHandler(app, root, &conf, syncHandler)
+	queryHandler := query.NewHandler(app, &conf)
+	viewsHandler := views.NewHandler(app, &conf)
+	propertiesHandler := properties.NewHandler(app, &conf)
+	bulkHandler := bulk.NewHandler(app, &conf)
+	replaceHandler := replace.NewHandler(app, root, &conf)
+	revisionsHandler := revisions.NewHandler(app, root, &conf)
+	recurrenceHandler := recurrence.NewHandler(app, &conf)
+	trackerHandler := tracker.NewHandler(app, &conf, syncHandler)
+	debtsHandler := debts.NewHandler(app, &conf, syncHandler)
+	goalsHandler := goals.NewHandler(app, &conf)
+	templatesHandler := templates.NewHandler(app, root, &conf, syncHandler)
+	groceriesHandler := groceries.NewHandler(app, &conf, syncHandler)
+	periodicHandler, err := periodic.NewHandler(app, root, &conf, templatesHandler, syncHandler)
+	if err != nil {
+		app.Logger().Error("error creating periodic notes handler", "error", err)
+		return
+	}
+	habitsHandler := habits.NewHandler(app, &conf, periodicHandler)
 
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 		se.InstallerFunc = CustomInstallerFunc(superuserEmail, superuserPassword)
@@ -55,16 +93,90 @@ func main() {
 
 		syncHandler.Routes(se)
 		caldavHandler.Routes(se)
+		queryHandler.Routes(se)
+		schemaHandler.Routes(se)
+		propertiesHandler.Routes(se)
+		bulkHandler.Routes(se)
+		replaceHandler.Routes(se)
+		revisionsHandler.Routes(se)
+		templatesHandler.Routes(se)
+		periodicHandler.Routes(se)
+		trackerHandler.Routes(se)
+		debtsHandler.Routes(se)
+		goalsHandler.Routes(se)
+		groceriesHandler.Routes(se)
+		habitsHandler.Routes(se)
+
+		viewsHandler.Sync()
 
 		// TODO: run this in a goroutine, but make sure that watcher is not running, while syncing
 		syncHandler.InitialSync()
+		propertiesHandler.Rebuild()
 		go syncHandler.WatcherManager()
+		go syncHandler.GitManager()
+		bulkHandler.ResumeInterrupted()
+		revisionsHandler.ScheduleThinning()
+		periodicHandler.ScheduleCreation()
 
 		return se.Next()
 	})
 
+	app.OnRecordCreate("files").BindFunc(func(e *core.RecordEvent) error {
+		schemaHandler.Annotate(e.Record)
+		return e.Next()
+	})
+	app.OnRecordUpdate("files").BindFunc(func(e *core.RecordEvent) error {
+		recurrenceHandler.Apply(e.Record)
+		schemaHandler.Annotate(e.Record)
+		return e.Next()
+	})
+
+	app.OnRecordCreateExecute("files").BindFunc(func(e *core.RecordEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if err := propertiesHandler.Replace(e.App, e.Record); err != nil {
+			return err
+		}
+		return revisionsHandler.Record(e.App, e.Record)
+	})
+	app.OnRecordUpdateExecute("files").BindFunc(func(e *core.RecordEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		if properties.Changed(e.Record) {
+			if err := propertiesHandler.Replace(e.App, e.Record); err != nil {
+				return err
+			}
+		}
+		return revisionsHandler.Record(e.App, e.Record)
+	})
+
+	app.OnRecordCreateRequest("files").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := schemaHandler.ValidateRequest(e.Record); err != nil {
+			return err
+		}
+		revisions.SetUser(e.Record, e.Auth)
+		e.Record.Set("origin", "db")
+		return e.Next()
+	})
+	app.OnRecordUpdateRequest("files").BindFunc(func(e *core.RecordRequestEvent) error {
+		if err := schemaHandler.ValidateRequest(e.Record); err != nil {
+			return err
+		}
+		revisions.SetUser(e.Record, e.Auth)
+		// the record may still carry the origin of the last sync from disk
+		e.Record.Set("origin", "db")
+		return groceriesHandler.GuardRequest(e)
+	})
+
+	app.OnRecordAfterCreateSuccess("files").BindFunc(func(e *core.RecordEvent) error {
+		syncHandler.TrackChange(e.Record)
+		return e.Next()
+	})
 	app.OnRecordAfterUpdateSuccess("files").BindFunc(func(e *core.RecordEvent) error {
 		syncHandler.OnRecordUpdate(e.Record)
+		syncHandler.TrackChange(e.Record)
 		return e.Next()
 	})
 
@@ -75,6 +187,15 @@ func main() {
 	})
 
 	app.RootCmd.AddCommand(syncHandler.SyncCmd())
+	app.RootCmd.AddCommand(syncHandler.WatchCmd())
+	app.RootCmd.AddCommand(syncHandler.VerifyCmd())
+	app.RootCmd.AddCommand(syncHandler.LsCmd())
+	app.RootCmd.AddCommand(syncHandler.GetCmd())
+	app.RootCmd.AddCommand(syncHandler.SetCmd())
+	app.RootCmd.AddCommand(syncHandler.NewCmd())
+	app.RootCmd.AddCommand(bulkHandler.BulkCmd())
+	app.RootCmd.AddCommand(trackerHandler.TrackCmd())
+	app.RootCmd.AddCommand(debtsHandler.DebtsCmd())
 
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_3446931122",
+					"hidden": false,
+					"id": "relation2359244304",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "file",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2324736937",
+					"max": 0,
+					"min": 0,
+					"name": "key",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number2049939232",
+					"max": null,
+					"min": 0,
+					"name": "idx",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2363381545",
+					"max": 0,
+					"min": 0,
+					"name": "type",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3065852031",
+					"max": 0,
+					"min": 0,
+					"name": "text",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number2182627547",
+					"max": null,
+					"min": null,
+					"name": "number",
+					"onlyInt": false,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "date2862495610",
+					"max": "",
+					"min": "",
+					"name": "date",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "bool1264587087",
+					"name": "bool",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "bool"
+				}
+			],
+			"id": "pbc_1417462914",
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_properties_file` + "`" + ` ON ` + "`" + `properties` + "`" + ` (` + "`" + `file` + "`" + `)",
+				"CREATE INDEX ` + "`" + `idx_properties_key_text` + "`" + ` ON ` + "`" + `properties` + "`" + ` (\n  ` + "`" + `key` + "`" + `,\n  ` + "`" + `text` + "`" + `\n)",
+				"CREATE INDEX ` + "`" + `idx_properties_key_number` + "`" + ` ON ` + "`" + `properties` + "`" + ` (\n  ` + "`" + `key` + "`" + `,\n  ` + "`" + `number` + "`" + `\n)",
+				"CREATE INDEX ` + "`" + `idx_properties_key_date` + "`" + ` ON ` + "`" + `properties` + "`" + ` (\n  ` + "`" + `key` + "`" + `,\n  ` + "`" + `date` + "`" + `\n)"
+			],
+			"listRule": null,
+			"name": "properties",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1417462914")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,96 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 0,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2381323470",
+					"max": 0,
+					"min": 0,
+					"name": "file",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "date1736347813",
+					"max": "",
+					"min": "",
+					"name": "removed",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				}
+			],
+			"id": "pbc_3719054186",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_caldav_resources_file_name` + "`" + ` ON ` + "`" + `caldav_resources` + "`" + ` (\n  ` + "`" + `file` + "`" + `,\n  ` + "`" + `name` + "`" + `\n)",
+				"CREATE INDEX ` + "`" + `idx_caldav_resources_removed` + "`" + ` ON ` + "`" + `caldav_resources` + "`" + ` (` + "`" + `removed` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "caldav_resources",
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3719054186")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}